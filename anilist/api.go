@@ -1,208 +1,303 @@
 package anilist
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"ipmanlk/ani2mal/cache"
 	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+	"log/slog"
 	"math"
-	"net/http"
-	"strings"
-	"time"
 )
 
-type graphQLRequest struct {
-	Query string `json:"query"`
+// Number of entries requested per MediaListCollection chunk
+const listChunkSize = 500
+
+// Upper bound on chunks per list, guards against a response that never reports its last chunk
+const maxListChunks = 200
+
+// Entries requested per page when fetching recently updated entries, the most Anilist allows
+const updatedPageSize = 50
+
+// Upper bound on pages of updated entries, a full sync is cheaper past this point
+const maxUpdatedPages = 40
+
+// returned by GetUserDataSince when more entries changed than are worth paging through
+var ErrTooManyChanges = errors.New("too many Anilist entries changed since the last sync")
+
+// Media status for each Anilist list entry status
+var mediaStatuses = map[string]models.MediaStatus{
+	"PLANNING":  models.MediaStatusPlanning,
+	"PAUSED":    models.MediaStatusPaused,
+	"CURRENT":   models.MediaStatusCurrent,
+	"REPEATING": models.MediaStatusCurrent,
+	"DROPPED":   models.MediaStatusDropped,
+	"COMPLETED": models.MediaStatusCompleted,
 }
 
-func GetUserData(username string, bearerToken *string) (*models.SourceData, error) {
-	anilistAnime, err := getList(username, models.MediaTypeAnime, bearerToken)
-	if err != nil {
-		return nil, &models.AppError{
-			Message: "Failed to fetch Anilist Anime List",
-			Err:     err,
+func (c *Client) GetUserData(ctx context.Context, userId int, bearerToken *string) (*models.SourceData, error) {
+	return c.fetchUserData(ctx, func(ctx context.Context, mediaType models.MediaType, handleEntries func([]models.AnilistEntry)) error {
+		return c.getList(ctx, userId, mediaType, bearerToken, handleEntries)
+	})
+}
+
+// fetches only the entries updated at or after since. Entries removed from a list
+// can't be noticed this way, a full fetch is needed for those
+func (c *Client) GetUserDataSince(ctx context.Context, userId int, since int64, bearerToken *string) (*models.SourceData, error) {
+	return c.fetchUserData(ctx, func(ctx context.Context, mediaType models.MediaType, handleEntries func([]models.AnilistEntry)) error {
+		return c.getUpdatedEntries(ctx, userId, mediaType, since, bearerToken, handleEntries)
+	})
+}
+
+// fetches the anime and manga lists at the same time, entries are formatted as soon as they arrive
+func (c *Client) fetchUserData(ctx context.Context, fetchList func(context.Context, models.MediaType, func([]models.AnilistEntry)) error) (*models.SourceData, error) {
+	resolver := newIdResolver()
+	animeData := models.NewSourceData()
+	mangaData := models.NewSourceData()
+
+	group, ctx := utils.NewGroup(ctx)
+
+	group.Go(func() error {
+		err := fetchList(ctx, models.MediaTypeAnime, func(entries []models.AnilistEntry) {
+			formattedAnime := formatEntries(entries, models.MediaTypeAnime, animeData, resolver)
+			animeData.Anime = append(animeData.Anime, formattedAnime...)
+		})
+		if err != nil {
+			return &models.AppError{
+				Message: "Failed to fetch Anilist Anime List",
+				Err:     err,
+			}
+		}
+		return nil
+	})
+
+	group.Go(func() error {
+		err := fetchList(ctx, models.MediaTypeManga, func(entries []models.AnilistEntry) {
+			formattedManga := formatEntries(entries, models.MediaTypeManga, mangaData, resolver)
+			mangaData.Manga = append(mangaData.Manga, formattedManga...)
+		})
+		if err != nil {
+			return &models.AppError{
+				Message: "Failed to fetch Anilist Manga List",
+				Err:     err,
+			}
 		}
+		return nil
+	})
+
+	if err := group.Wait(); err != nil {
+		return nil, err
 	}
 
-	anilistManga, err := getList(username, models.MediaTypeManga, bearerToken)
+	animeData.Merge(mangaData)
+
+	return animeData, nil
+}
+
+// returns the user that owns the access token
+func (c *Client) GetViewer(ctx context.Context, bearerToken string) (*models.AnilistViewer, error) {
+	data, err := executeQuery[models.AnilistViewerData](ctx, c, viewerQuery, nil, &bearerToken)
 	if err != nil {
 		return nil, &models.AppError{
-			Message: "Failed to fetch Anilist Manga List",
+			Message: "Failed to fetch the Anilist user",
 			Err:     err,
 		}
 	}
 
-	stats := models.SourceStats{}
-	entriesMap := make(map[int]models.Media)
-	formattedAnime := formatListResponse(anilistAnime, models.MediaTypeAnime, &stats, entriesMap)
-	formattedManga := formatListResponse(anilistManga, models.MediaTypeManga, &stats, entriesMap)
+	if data.Viewer == nil {
+		return nil, &models.AppError{
+			Message: "Anilist did not return the user for the access token",
+		}
+	}
 
-	return &models.SourceData{
-		Stats:    stats,
-		MediaMap: entriesMap,
-		Anime:    formattedAnime,
-		Manga:    formattedManga,
-	}, nil
+	return data.Viewer, nil
 }
 
-func getList(username string, mediaType models.MediaType, bearerToken *string) (*models.AnilistRes, error) {
-	query := getGraphQuery(username, mediaType)
+// fetches a list chunk by chunk and passes its entries to handleEntries once every chunk arrived.
+// A failed chunk fails the whole list so a partial list is never returned as complete
+func (c *Client) getList(ctx context.Context, userId int, mediaType models.MediaType, bearerToken *string, handleEntries func([]models.AnilistEntry)) error {
+	groupCtx := cache.Group(cache.Cacheable(ctx))
+	entries, err := c.getListChunks(groupCtx, userId, mediaType, bearerToken)
 
-	requestBody := graphQLRequest{
-		Query: query,
+	// chunks cached at different times could miss entries that moved between them
+	if err == nil && !cache.Consistent(groupCtx) {
+		slog.DebugContext(ctx, "Cached Anilist list chunks are out of date, fetching the whole list", "type", mediaType)
+		entries, err = c.getListChunks(cache.Refresh(cache.Cacheable(ctx)), userId, mediaType, bearerToken)
 	}
-
-	reqBodyJSON, err := json.Marshal(requestBody)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	req, err := http.NewRequest("POST", "https://graphql.anilist.co", strings.NewReader(string(reqBodyJSON)))
-	if err != nil {
-		return nil, err
-	}
+	handleEntries(entries)
+	return nil
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
+func (c *Client) getListChunks(ctx context.Context, userId int, mediaType models.MediaType, bearerToken *string) ([]models.AnilistEntry, error) {
+	entries := make([]models.AnilistEntry, 0)
 
-	if bearerToken != nil {
-		req.Header.Set("Authorization", "Bearer "+*bearerToken)
-	}
+	for chunk := 1; chunk <= maxListChunks; chunk++ {
+		collection, err := c.getListChunk(ctx, userId, mediaType, chunk, bearerToken)
+		if err != nil {
+			return nil, &models.AppError{
+				Message: fmt.Sprintf("Failed to fetch chunk %d of the Anilist %s list", chunk, mediaType),
+				Err:     err,
+			}
+		}
 
-	if err != nil {
-		return nil, &models.AppError{
-			Message: "Failed to construct Anilist request",
-			Err:     err,
+		// custom lists only repeat entries that are already in the status lists
+		for _, list := range collection.Lists {
+			if !list.IsCustomList {
+				entries = append(entries, list.Entries...)
+			}
 		}
-	}
 
-	client := &http.Client{
-		Timeout: 15 * time.Second,
+		if !collection.HasNextChunk {
+			return entries, nil
+		}
 	}
 
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, &models.AppError{
-			Message: "Failed to contact Anilist API",
-			Err:     err,
-		}
+	return nil, &models.AppError{
+		Message: fmt.Sprintf("Anilist %s list has more than %d chunks", mediaType, maxListChunks),
 	}
-	defer res.Body.Close()
+}
 
-	var anilistRes models.AnilistRes
+func (c *Client) getListChunk(ctx context.Context, userId int, mediaType models.MediaType, chunk int, bearerToken *string) (*models.AnilistMediaListCollection, error) {
+	variables := map[string]any{
+		"userId":   userId,
+		"type":     getAnilistMediaType(mediaType),
+		"chunk":    chunk,
+		"perChunk": listChunkSize,
+	}
 
-	err = json.NewDecoder(res.Body).Decode(&anilistRes)
+	data, err := executeQuery[models.AnilistResData](ctx, c, mediaListCollectionQuery, variables, bearerToken)
 	if err != nil {
+		return nil, err
+	}
+
+	if data.MediaListCollection == nil {
 		return nil, &models.AppError{
-			Message: "Failed to Parse Anilist Response",
-			Err:     err,
+			Message: "Anilist response is missing the list data",
 		}
 	}
 
-	return &anilistRes, nil
+	return data.MediaListCollection, nil
 }
 
-func formatListResponse(res *models.AnilistRes, mediaType models.MediaType, stats *models.SourceStats, entriesMap map[int]models.Media) []models.Media {
-	formattedList := make([]models.Media, 0)
+// pages through a list from the most recently updated entry and stops at the first entry older than since
+func (c *Client) getUpdatedEntries(ctx context.Context, userId int, mediaType models.MediaType, since int64, bearerToken *string, handleEntries func([]models.AnilistEntry)) error {
+	for page := 1; page <= maxUpdatedPages; page++ {
+		variables := map[string]any{
+			"userId":  userId,
+			"type":    getAnilistMediaType(mediaType),
+			"page":    page,
+			"perPage": updatedPageSize,
+		}
 
-	for _, list := range res.Data.MediaListCollection.Lists {
-		if list.IsCustomList {
-			continue
+		data, err := executeQuery[models.AnilistPageData](ctx, c, updatedMediaListQuery, variables, bearerToken)
+		if err != nil {
+			return &models.AppError{
+				Message: fmt.Sprintf("Failed to fetch page %d of the updated Anilist %s entries", page, mediaType),
+				Err:     err,
+			}
 		}
 
-		var status models.MediaStatus
+		if data.Page == nil {
+			return &models.AppError{
+				Message: "Anilist response is missing the page data",
+			}
+		}
 
-		switch strings.ToLower(list.Name) {
-		case "planning":
-			status = models.MediaStatusPlanning
-		case "paused":
-			status = models.MediaStatusPaused
-		case "watching", "reading":
-			status = models.MediaStatusCurrent
-		case "dropped":
-			status = models.MediaStatusDropped
-		case "completed":
-			status = models.MediaStatusCompleted
+		entries := data.Page.MediaList
+		for i, entry := range entries {
+			if entry.UpdatedAt < since {
+				handleEntries(entries[:i])
+				return nil
+			}
 		}
+		handleEntries(entries)
 
-		if status == "watching" || status == "reading" {
-			status = models.MediaStatusCurrent
+		if !data.Page.PageInfo.HasNextPage {
+			return nil
 		}
+	}
 
-		for _, i := range list.Entries {
-			if i.Media.IDMal == nil {
-				continue
-			}
+	return &models.AppError{
+		Message: fmt.Sprintf("More than %d pages of Anilist %s entries changed", maxUpdatedPages, mediaType),
+		Err:     ErrTooManyChanges,
+	}
+}
 
-			repeat := false
-			if i.Repeat == 1 {
-				repeat = true
-			}
+func formatEntries(entries []models.AnilistEntry, mediaType models.MediaType, data *models.SourceData, resolver *idResolver) []models.Media {
+	formattedList := make([]models.Media, 0)
 
-			media := models.Media{
-				ID:       *i.Media.IDMal,
-				Title:    i.Media.Title.Romaji,
-				Progress: i.Progress,
-				Score:    int(math.Round(i.Score)),
-				Status:   status,
-				Repeat:   repeat,
-				Type:     mediaType,
-				Length:   getMediaLength(&i.Media),
+	for _, i := range entries {
+		idMal, ignore := resolver.resolve(&i.Media, mediaType)
+		if ignore {
+			if idMal != nil {
+				data.Ignored = append(data.Ignored, models.MediaKey{Type: mediaType, ID: *idMal})
 			}
+			continue
+		}
 
-			formattedList = append(formattedList, media)
-			entriesMap[media.ID] = media
-
-			// update stats reference data
-			switch status {
-			case models.MediaStatusPlanning:
-				stats.Planning += 1
-			case models.MediaStatusPaused:
-				stats.Paused += 1
-			case models.MediaStatusCurrent:
-				stats.Current += 1
-			case models.MediaStatusDropped:
-				stats.Dropped += 1
-			case models.MediaStatusCompleted:
-				stats.Completed += 1
-			}
+		repeat := false
+		if i.Repeat == 1 {
+			repeat = true
 		}
+
+		media := models.Media{
+			Title:     i.Media.Title.Romaji,
+			Progress:  i.Progress,
+			Score:     int(math.Round(i.Score)),
+			Status:    mediaStatuses[i.Status],
+			Repeat:    repeat,
+			Type:      mediaType,
+			Length:    getMediaLength(&i.Media),
+			UpdatedAt: i.UpdatedAt,
+		}
+
+		if i.Media.Duration != nil {
+			media.Duration = *i.Media.Duration
+		}
+		if i.Notes != nil {
+			media.Notes = *i.Notes
+		}
+		media.StartDate = formatFuzzyDate(i.StartedAt)
+		media.FinishDate = formatFuzzyDate(i.CompletedAt)
+
+		// entries without a MAL ID can't be synced, report them instead
+		if idMal == nil {
+			data.Unmapped = append(data.Unmapped, getUnmappedMedia(&i.Media, media))
+			continue
+		}
+		media.ID = *idMal
+
+		formattedList = append(formattedList, media)
+		data.MediaMap[media.Key()] = media
 	}
 
 	return formattedList
 }
 
-func getGraphQuery(username string, mediaType models.MediaType) string {
-	anilistMediaType := "ANIME"
+// formats the known parts of a date as YYYY-MM-DD, YYYY-MM or YYYY
+func formatFuzzyDate(date models.AnilistFuzzyDate) string {
+	if date.Year == nil {
+		return ""
+	}
+	if date.Month == nil {
+		return fmt.Sprintf("%04d", *date.Year)
+	}
+	if date.Day == nil {
+		return fmt.Sprintf("%04d-%02d", *date.Year, *date.Month)
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", *date.Year, *date.Month, *date.Day)
+}
 
+func getAnilistMediaType(mediaType models.MediaType) string {
 	if mediaType == models.MediaTypeManga {
-		anilistMediaType = "MANGA"
-	}
-
-	return fmt.Sprintf(`{
-      MediaListCollection(userName: "%s", type: %s) {
-        lists {
-          entries {
-            id
-            status
-            score(format: POINT_10)
-            progress
-            notes
-            repeat
-            media {
-              chapters
-              volumes
-              idMal
-              episodes
-              title { romaji }
-            }
-          }
-          name
-          isCustomList
-          isSplitCompletedList
-          status
-        }
-      }
-  }`, username, anilistMediaType)
+		return "MANGA"
+	}
+	return "ANIME"
 }
 
 func getMediaLength(media *models.AnilistMedia) int {
@@ -213,3 +308,21 @@ func getMediaLength(media *models.AnilistMedia) int {
 	}
 	return 0
 }
+
+func getUnmappedMedia(anilistMedia *models.AnilistMedia, media models.Media) models.UnmappedMedia {
+	unmappedMedia := models.UnmappedMedia{
+		AnilistID: anilistMedia.ID,
+		Format:    anilistMedia.Format,
+		Media:     media,
+	}
+
+	if anilistMedia.StartDate.Year != nil {
+		unmappedMedia.Year = *anilistMedia.StartDate.Year
+	}
+
+	if english := anilistMedia.Title.English; english != nil && *english != "" && *english != media.Title {
+		unmappedMedia.AltTitles = append(unmappedMedia.AltTitles, *english)
+	}
+
+	return unmappedMedia
+}
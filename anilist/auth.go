@@ -2,14 +2,17 @@ package anilist
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/metrics"
 	"ipmanlk/ani2mal/models"
 	"ipmanlk/ani2mal/utils"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -28,7 +31,7 @@ type refreshTokenReqData struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-func PerformAuth() {
+func (c *Client) PerformAuth(ctx context.Context) {
 	fmt.Print("Enter Anilist Username: ")
 	username := utils.GetStrInput()
 
@@ -38,61 +41,112 @@ func PerformAuth() {
 	fmt.Print("Enter Client Secret: ")
 	clientSecret := utils.GetStrInput()
 
-	loginURL := getAuthenticationURL(clientId)
+	loginURL := c.getAuthenticationURL(clientId)
 	fmt.Printf("Login URL: %s\n", loginURL)
 
 	fmt.Print("Enter the code from the login URL: ")
 	code := utils.GetStrInput()
 
-	res, err := getAccessTokenRes(clientId, clientSecret, code)
+	res, err := c.getAccessTokenRes(ctx, clientId, clientSecret, code)
 
 	if err != nil {
-		log.Fatal(err.Error())
+		utils.Fatal("Failed to get the Anilist access token", "error", err)
 	}
 
+	viewer, err := c.GetViewer(ctx, res.AccessToken)
+	if err != nil {
+		utils.Fatal("Failed to fetch the Anilist user", "error", err)
+	}
+
+	warnUsernameMismatch(username, viewer)
+
 	appConfig := config.GetAppConfig()
 
 	appConfig.SaveAnilistConfig(&models.AnilistConfig{
 		Username:     username,
+		ViewerName:   viewer.Name,
+		UserId:       viewer.ID,
+		ListOptions:  viewer.MediaListOptions,
 		ClientId:     clientId,
 		ClientSecret: clientSecret,
 		TokenRes:     *res,
 	})
 
-	fmt.Println("Authentication successful. Access token has been saved.")
+	fmt.Printf("Authentication successful as %s. Access token has been saved.\n", viewer.Name)
 }
 
-func GetAccessCode() (string, error) {
-	anilistConfig := config.GetAppConfig().GetAnilistConfig()
+// looks up the owner of the token and keeps the stored user details up to date.
+// Lists are always fetched for the token owner, a different configured username only produces a warning
+func (c *Client) GetAuthenticatedUser(ctx context.Context, bearerToken string) (*models.AnilistViewer, error) {
+	viewer, err := c.GetViewer(ctx, bearerToken)
+	if err != nil {
+		return nil, err
+	}
 
-	// check if token is expired or will expire soon
-	currentTime := time.Now()
-	expirationTime := currentTime.Add(time.Duration(anilistConfig.TokenRes.ExpiresIn) * time.Second)
-	expirationBuffer := 20 * time.Minute
+	appConfig := config.GetAppConfig()
+	anilistConfig := appConfig.GetAnilistConfig()
+
+	warnUsernameMismatch(anilistConfig.Username, viewer)
+
+	if anilistConfig.UserId != viewer.ID || anilistConfig.ViewerName != viewer.Name || anilistConfig.ListOptions.ScoreFormat != viewer.MediaListOptions.ScoreFormat {
+		anilistConfig.UserId = viewer.ID
+		anilistConfig.ViewerName = viewer.Name
+		anilistConfig.ListOptions = viewer.MediaListOptions
+		appConfig.SaveAnilistConfig(anilistConfig)
+	}
 
-	if expirationTime.After(currentTime.Add(expirationBuffer)) {
-		// token is not expired
+	return viewer, nil
+}
+
+// the username entered at login is kept as it is, only the token owner's list is synced
+func warnUsernameMismatch(username string, viewer *models.AnilistViewer) {
+	if username != "" && !strings.EqualFold(username, viewer.Name) {
+		slog.Warn("Configured Anilist username does not match the token owner, syncing the token owner", "configured", username, "owner", viewer.Name)
+	}
+}
+
+// Tokens are refreshed when they expire within this window so a sync never runs with an expired token
+const tokenRefreshBuffer = 20 * time.Minute
+
+func (c *Client) GetAccessCode(ctx context.Context) (string, error) {
+	anilistConfig := config.GetAppConfig().GetAnilistConfig()
+
+	if !anilistConfig.TokenRes.ExpiresWithin(tokenRefreshBuffer) {
 		return anilistConfig.TokenRes.AccessToken, nil
 	}
 
-	// token is expired and new one should be requested
-	res, err := getRefreshTokenRes(anilistConfig.ClientId, anilistConfig.ClientSecret, anilistConfig.TokenRes.RefreshToken)
+	res, err := c.getRefreshTokenRes(ctx, anilistConfig.ClientId, anilistConfig.ClientSecret, anilistConfig.TokenRes.RefreshToken)
 	if err != nil {
+		metrics.TokenRefreshes.Inc("anilist", "failure")
+
+		// a token that has not expired yet is still usable, the refresh is retried on the next run
+		if !anilistConfig.TokenRes.IsExpired() {
+			slog.Warn("Failed to refresh the Anilist access token, using the current one", "error", err)
+			return anilistConfig.TokenRes.AccessToken, nil
+		}
 		return "", err
 	}
 
+	metrics.TokenRefreshes.Inc("anilist", "success")
+	slog.Info("Refreshed the Anilist access token")
+
+	// the refresh token is only replaced when a new one is issued
+	if res.RefreshToken == "" {
+		res.RefreshToken = anilistConfig.TokenRes.RefreshToken
+	}
+
 	anilistConfig.TokenRes = *res
 	config.GetAppConfig().SaveAnilistConfig(anilistConfig)
 
 	return res.AccessToken, nil
 }
 
-func getAuthenticationURL(clientId string) string {
-	return fmt.Sprintf("https://anilist.co/api/v2/oauth/authorize?client_id=%s&redirect_uri=%s&response_type=code", clientId, "http://localhost:3000")
+func (c *Client) getAuthenticationURL(clientId string) string {
+	return fmt.Sprintf("%s/authorize?client_id=%s&redirect_uri=%s&response_type=code", c.oauthUrl, clientId, "http://localhost:3000")
 }
 
 // exchanges the auth code for an access token
-func getAccessTokenRes(clientId, clientSecret, authorizationCode string) (*models.TokenRes, error) {
+func (c *Client) getAccessTokenRes(ctx context.Context, clientId, clientSecret, authorizationCode string) (*models.TokenRes, error) {
 	data := accessTokenReqData{
 		GrantType:    "authorization_code",
 		ClientID:     clientId,
@@ -101,26 +155,22 @@ func getAccessTokenRes(clientId, clientSecret, authorizationCode string) (*model
 		Code:         authorizationCode,
 	}
 
-	return sendTokenRequest(data)
+	return c.sendTokenRequest(ctx, data)
 }
 
 // request a new access token using refresh token
-func getRefreshTokenRes(clientId, clientSecret, refreshToken string) (*models.TokenRes, error) {
+func (c *Client) getRefreshTokenRes(ctx context.Context, clientId, clientSecret, refreshToken string) (*models.TokenRes, error) {
 	data := refreshTokenReqData{
 		GrantType:    "refresh_token",
 		ClientID:     clientId,
 		ClientSecret: clientSecret,
 		RefreshToken: refreshToken,
 	}
-	return sendTokenRequest(data)
+	return c.sendTokenRequest(ctx, data)
 }
 
-func sendTokenRequest(data any) (*models.TokenRes, error) {
-	tokenEndpoint := "https://anilist.co/api/v2/oauth/token"
-
-	client := &http.Client{
-		Timeout: 15 * time.Second,
-	}
+func (c *Client) sendTokenRequest(ctx context.Context, data any) (*models.TokenRes, error) {
+	tokenEndpoint := c.oauthUrl + "/token"
 
 	reqBody, err := json.Marshal(data)
 	if err != nil {
@@ -130,7 +180,7 @@ func sendTokenRequest(data any) (*models.TokenRes, error) {
 		}
 	}
 
-	req, err := http.NewRequest("POST", tokenEndpoint, bytes.NewReader(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenEndpoint, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, &models.AppError{
 			Message: "Error creating anilist token request",
@@ -141,7 +191,8 @@ func sendTokenRequest(data any) (*models.TokenRes, error) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	res, err := client.Do(req)
+	requestedAt := time.Now()
+	res, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, &models.AppError{
 			Message: "Error making anilist token request",
@@ -172,5 +223,7 @@ func sendTokenRequest(data any) (*models.TokenRes, error) {
 		}
 	}
 
+	tokenRes.SetExpiry(requestedAt)
+
 	return &tokenRes, nil
 }
@@ -0,0 +1,35 @@
+package anilist
+
+import (
+	"ipmanlk/ani2mal/cache"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultGraphQLUrl = "https://graphql.anilist.co"
+	defaultOAuthUrl   = "https://anilist.co/api/v2/oauth"
+)
+
+// Anilist API client, one client and its connections are shared by every request
+type Client struct {
+	httpClient *http.Client
+	graphQLUrl string
+	oauthUrl   string
+}
+
+// responses to list fetches are cached in store, a nil store turns caching off
+func NewClient(settings *models.Settings, store *cache.Store) (*Client, error) {
+	httpClient, err := utils.NewHTTPClient("anilist", settings.HTTP, store)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		httpClient: httpClient,
+		graphQLUrl: utils.OrDefault(settings.Anilist.GraphQLURL, defaultGraphQLUrl),
+		oauthUrl:   strings.TrimSuffix(utils.OrDefault(settings.Anilist.OAuthURL, defaultOAuthUrl), "/"),
+	}, nil
+}
@@ -0,0 +1,157 @@
+package anilist
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"ipmanlk/ani2mal/metrics"
+	"ipmanlk/ani2mal/models"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Attempts for each request when Anilist responds with a rate limit
+const maxQueryAttempts = 3
+
+// GraphQL operation along with the fragments its query spreads
+type operation struct {
+	name      string
+	query     string
+	fragments []string
+}
+
+func (op operation) document() string {
+	return strings.Join(append([]string{op.query}, op.fragments...), "\n")
+}
+
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response envelope, errors can be present with or without data
+type graphQLResponse[T any] struct {
+	Data   *T                    `json:"data"`
+	Errors []models.AnilistError `json:"errors"`
+}
+
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("Anilist rate limit exceeded, retry after %s", e.retryAfter)
+}
+
+// runs an operation and decodes its data into T, retrying when rate limited
+func executeQuery[T any](ctx context.Context, c *Client, op operation, variables map[string]any, bearerToken *string) (*T, error) {
+	for attempt := 1; ; attempt++ {
+		data, err := sendQuery[T](ctx, c, op, variables, bearerToken)
+
+		rateLimitErr, ok := err.(*rateLimitError)
+		if !ok || attempt == maxQueryAttempts {
+			return data, err
+		}
+
+		metrics.RateLimitWaits.Inc("anilist")
+		metrics.RateLimitWaitSeconds.Add(rateLimitErr.retryAfter.Seconds(), "anilist")
+		slog.Warn("Anilist rate limit exceeded, waiting before retrying", "retry_after", rateLimitErr.retryAfter, "attempt", attempt)
+
+		select {
+		case <-time.After(rateLimitErr.retryAfter):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func sendQuery[T any](ctx context.Context, c *Client, op operation, variables map[string]any, bearerToken *string) (*T, error) {
+	requestBody := graphQLRequest{
+		Query:         op.document(),
+		OperationName: op.name,
+		Variables:     variables,
+	}
+
+	reqBodyJSON, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.graphQLUrl, bytes.NewReader(reqBodyJSON))
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to construct Anilist request",
+			Err:     err,
+		}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	if bearerToken != nil {
+		req.Header.Set("Authorization", "Bearer "+*bearerToken)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to contact Anilist API",
+			Err:     err,
+		}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		return nil, &rateLimitError{retryAfter: getRetryAfter(res)}
+	}
+
+	var graphQLRes graphQLResponse[T]
+
+	err = json.NewDecoder(res.Body).Decode(&graphQLRes)
+	if err != nil {
+		return nil, &models.AppError{
+			Message: fmt.Sprintf("Failed to Parse Anilist Response, status code: %d", res.StatusCode),
+			Err:     err,
+		}
+	}
+
+	if len(graphQLRes.Errors) > 0 {
+		messages := make([]string, len(graphQLRes.Errors))
+		for i, graphQLErr := range graphQLRes.Errors {
+			messages[i] = graphQLErr.Message
+		}
+
+		return nil, &models.AppError{
+			Message: fmt.Sprintf("Anilist %s query failed: %s", op.name, strings.Join(messages, "; ")),
+		}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, &models.AppError{
+			Message: fmt.Sprintf("Anilist request failed, status code: %d", res.StatusCode),
+		}
+	}
+
+	if graphQLRes.Data == nil {
+		return nil, &models.AppError{
+			Message: fmt.Sprintf("Anilist %s response has no data", op.name),
+		}
+	}
+
+	return graphQLRes.Data, nil
+}
+
+// Anilist sends the number of seconds to wait in Retry-After
+func getRetryAfter(res *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return time.Minute
+	}
+
+	return time.Duration(seconds) * time.Second
+}
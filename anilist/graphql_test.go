@@ -0,0 +1,92 @@
+package anilist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testViewerData struct {
+	Viewer struct {
+		ID int `json:"id"`
+	} `json:"Viewer"`
+}
+
+var testOperation = operation{name: "TestViewer", query: "query TestViewer { Viewer { id } }"}
+
+// answers with a rate limit until limited requests have been made
+func newRateLimitedServer(t *testing.T, limited int32, retryAfter string) (*Client, *atomic.Int32) {
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.OperationName != testOperation.name {
+			t.Errorf("request = %+v, %v, want the %s operation", req, err, testOperation.name)
+		}
+
+		if requests.Add(1) <= limited {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.Write([]byte(`{"data":{"Viewer":{"id":42}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return &Client{httpClient: server.Client(), graphQLUrl: server.URL}, &requests
+}
+
+func TestExecuteQueryRetriesRateLimit(t *testing.T) {
+	client, requests := newRateLimitedServer(t, 1, "1")
+
+	data, err := executeQuery[testViewerData](context.Background(), client, testOperation, nil, nil)
+	if err != nil {
+		t.Fatalf("executeQuery() error = %v", err)
+	}
+	if data.Viewer.ID != 42 {
+		t.Errorf("Viewer.ID = %d, want 42", data.Viewer.ID)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("%d requests were made, want 2", got)
+	}
+}
+
+func TestExecuteQueryRateLimitWaitCancelled(t *testing.T) {
+	client, requests := newRateLimitedServer(t, maxQueryAttempts, "60")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := executeQuery[testViewerData](ctx, client, testOperation, nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("executeQuery() error = %v, want context.DeadlineExceeded", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("%d requests were made, want 1", got)
+	}
+}
+
+func TestGetRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"30", 30 * time.Second},
+		{"", time.Minute},
+		{"0", time.Minute},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", time.Minute},
+	}
+
+	for _, tt := range tests {
+		res := &http.Response{Header: http.Header{"Retry-After": []string{tt.header}}}
+		if got := getRetryAfter(res); got != tt.want {
+			t.Errorf("getRetryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
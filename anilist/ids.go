@@ -0,0 +1,45 @@
+package anilist
+
+import (
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/xref"
+	"log/slog"
+)
+
+// decides the MAL ID of each Anilist entry
+type idResolver struct {
+	mappings map[int]models.IdMapping
+	xrefDB   *xref.Database
+}
+
+func newIdResolver() *idResolver {
+	return &idResolver{
+		mappings: config.GetAppConfig().GetIdMappings(),
+		xrefDB:   xref.Load(),
+	}
+}
+
+// returns the MAL ID for an entry in the order user mappings, cross-reference database, Anilist idMal.
+// ignore is true when the user has asked for the entry to never be synced, the MAL ID is still
+// returned when known so the MAL entry can be left alone
+func (r *idResolver) resolve(media *models.AnilistMedia, mediaType models.MediaType) (malId *int, ignore bool) {
+	mapping, mapped := r.mappings[media.ID]
+	if mapped && !mapping.Ignore {
+		return &mapping.MalID, false
+	}
+	if mapped && mapping.MalID != 0 {
+		return &mapping.MalID, true
+	}
+
+	if r.xrefDB != nil {
+		if xrefId, ok := r.xrefDB.Translate(mediaType, models.XrefProviderAnilist, media.ID, models.XrefProviderMal); ok {
+			if media.IDMal != nil && *media.IDMal != xrefId {
+				slog.Debug("idMal disagrees with the cross-reference database", "anilist_id", media.ID, "title", media.Title.Romaji, "id_mal", *media.IDMal, "xref_id", xrefId)
+			}
+			return &xrefId, mapped
+		}
+	}
+
+	return media.IDMal, mapped
+}
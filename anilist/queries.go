@@ -0,0 +1,70 @@
+package anilist
+
+// Fields of a single list entry, shared by every query that returns list entries
+const mediaListEntryFragment = `fragment MediaListEntry on MediaList {
+  id
+  status
+  score(format: POINT_10)
+  progress
+  notes
+  repeat
+  updatedAt
+  startedAt { year month day }
+  completedAt { year month day }
+  media {
+    id
+    chapters
+    volumes
+    idMal
+    episodes
+    duration
+    format
+    startDate { year month day }
+    title { romaji english }
+  }
+}`
+
+var mediaListCollectionQuery = operation{
+	name: "MediaListCollection",
+	query: `query MediaListCollection($userId: Int, $type: MediaType, $chunk: Int, $perChunk: Int) {
+  MediaListCollection(userId: $userId, type: $type, chunk: $chunk, perChunk: $perChunk) {
+    hasNextChunk
+    lists {
+      entries { ...MediaListEntry }
+      name
+      isCustomList
+      isSplitCompletedList
+      status
+    }
+  }
+}`,
+	fragments: []string{mediaListEntryFragment},
+}
+
+// entries of a list, most recently updated first
+var updatedMediaListQuery = operation{
+	name: "UpdatedMediaList",
+	query: `query UpdatedMediaList($userId: Int, $type: MediaType, $page: Int, $perPage: Int) {
+  Page(page: $page, perPage: $perPage) {
+    pageInfo { hasNextPage }
+    mediaList(userId: $userId, type: $type, sort: UPDATED_TIME_DESC) { ...MediaListEntry }
+  }
+}`,
+	fragments: []string{mediaListEntryFragment},
+}
+
+var viewerQuery = operation{
+	name: "Viewer",
+	query: `query Viewer {
+  Viewer {
+    id
+    name
+    mediaListOptions {
+      scoreFormat
+      rowOrder
+      animeList { splitCompletedSectionByFormat customLists }
+      mangaList { splitCompletedSectionByFormat customLists }
+    }
+  }
+}`,
+}
@@ -0,0 +1,302 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Entries are deleted once they are this old, even when they could still be revalidated
+const maxEntryAge = 7 * 24 * time.Hour
+
+// Response headers kept with an entry
+var storedHeaders = []string{"Content-Type", "ETag", "Last-Modified"}
+
+type contextKey int
+
+const (
+	cacheableKey contextKey = iota
+	refreshKey
+	groupKey
+)
+
+// marks the requests made with ctx as safe to answer from the cache
+func Cacheable(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheableKey, true)
+}
+
+// makes the requests made with ctx skip fresh cache entries, for commands that must see the current state.
+// Responses are still stored and entries with validators are revalidated instead of downloaded again
+func Refresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey, true)
+}
+
+// Requests that have to come from the same point in time, such as the pages of a list
+type group struct {
+	mu     sync.Mutex
+	hit    bool
+	missed bool
+}
+
+// groups the cacheable requests made with ctx. Once one of them is sent to the server
+// the rest skip fresh cache entries too, so later pages are never older than earlier ones
+func Group(ctx context.Context) context.Context {
+	return context.WithValue(ctx, groupKey, &group{})
+}
+
+// false when some requests of the group were answered from the cache and earlier ones were not.
+// The responses may then come from different points in time and the whole group should be refreshed
+func Consistent(ctx context.Context) bool {
+	g, _ := ctx.Value(groupKey).(*group)
+	if g == nil {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !(g.hit && g.missed)
+}
+
+// records whether a request of the group was answered from the cache
+func (g *group) record(hit bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if hit {
+		g.hit = true
+	} else {
+		g.missed = true
+	}
+}
+
+func (g *group) hasMissed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.missed
+}
+
+// On-disk response cache, entries are grouped per service and user
+type Store struct {
+	dir string
+	ttl time.Duration
+}
+
+type entry struct {
+	StoredAt int64       `json:"stored_at"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+}
+
+func NewStore(dir string, ttl time.Duration) *Store {
+	store := &Store{dir: dir, ttl: ttl}
+	store.prune()
+	return store
+}
+
+// deletes every cached response
+func Clear(dir string) error {
+	return os.RemoveAll(dir)
+}
+
+// wraps next so cacheable requests are answered from the store. Successful writes
+// drop every entry of the same service and user since they may have changed any list
+func (s *Store) Transport(service string, next http.RoundTripper) http.RoundTripper {
+	return &transport{store: s, service: service, next: next}
+}
+
+type transport struct {
+	store   *Store
+	service string
+	next    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	userDir := filepath.Join(t.store.dir, t.service, getUserKey(req))
+
+	if cacheable, _ := ctx.Value(cacheableKey).(bool); !cacheable {
+		res, err := t.next.RoundTrip(req)
+		if err == nil && isWrite(req.Method) && res.StatusCode < 300 {
+			if err := os.RemoveAll(userDir); err != nil {
+				slog.Warn("Failed to invalidate the response cache", "service", t.service, "error", err)
+			}
+		}
+		return res, err
+	}
+
+	key, err := getRequestKey(req)
+	if err != nil {
+		return nil, err
+	}
+	entryPath := filepath.Join(userDir, key+".json")
+
+	cached := t.store.load(entryPath)
+	refresh, _ := ctx.Value(refreshKey).(bool)
+	g, _ := ctx.Value(groupKey).(*group)
+	if g != nil && g.hasMissed() {
+		refresh = true
+	}
+
+	if cached != nil && !refresh && time.Since(time.Unix(cached.StoredAt, 0)) < t.store.ttl {
+		slog.DebugContext(ctx, "Served from cache", "service", t.service, "method", req.Method, "age", time.Since(time.Unix(cached.StoredAt, 0)).Round(time.Second))
+		if g != nil {
+			g.record(true)
+		}
+		return cached.response(req), nil
+	}
+
+	// a revalidated entry is as current as a download
+	if g != nil {
+		g.record(false)
+	}
+
+	// conditional headers only mean something for GET requests
+	if cached != nil && req.Method == http.MethodGet {
+		req = req.Clone(ctx)
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := cached.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && cached != nil:
+		res.Body.Close()
+		cached.StoredAt = time.Now().Unix()
+		t.store.save(entryPath, cached)
+		slog.DebugContext(ctx, "Revalidated cache entry", "service", t.service)
+		return cached.response(req), nil
+
+	case res.StatusCode == http.StatusOK:
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		stored := &entry{StoredAt: time.Now().Unix(), Status: res.StatusCode, Header: http.Header{}, Body: body}
+		for _, name := range storedHeaders {
+			if value := res.Header.Get(name); value != "" {
+				stored.Header.Set(name, value)
+			}
+		}
+		t.store.save(entryPath, stored)
+
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		return res, nil
+	}
+
+	return res, nil
+}
+
+func (s *Store) load(path string) *entry {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var cached entry
+	if err := json.Unmarshal(content, &cached); err != nil {
+		return nil
+	}
+
+	return &cached
+}
+
+// a failed write only costs a download next time, so it is logged rather than returned
+func (s *Store) save(path string, cached *entry) {
+	content, err := json.Marshal(cached)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(path, content, 0600)
+	}
+	if err != nil {
+		slog.Warn("Failed to write to the response cache", "path", path, "error", err)
+	}
+}
+
+func (s *Store) prune() {
+	filepath.WalkDir(s.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && time.Since(info.ModTime()) > maxEntryAge {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
+
+func (e *entry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(e.Status) + " " + http.StatusText(e.Status),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// the token identifies the user, only a hash of it is ever written to disk
+func getUserKey(req *http.Request) string {
+	authorization := req.Header.Get("Authorization")
+	if authorization == "" {
+		return "anonymous"
+	}
+
+	sum := sha256.Sum256([]byte(authorization))
+	return hex.EncodeToString(sum[:8])
+}
+
+// hashes the method, URL and body. The body is read from a copy so the request is left untouched
+func getRequestKey(req *http.Request) (string, error) {
+	hash := sha256.New()
+	io.WriteString(hash, req.Method+" "+req.URL.String()+"\n")
+
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return "", errors.New("cacheable request body can't be read twice")
+		}
+
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+
+		if _, err := io.Copy(hash, body); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// GraphQL reads are POST requests too, so only the REST write methods count
+func isWrite(method string) bool {
+	return method == http.MethodPut || method == http.MethodPatch || method == http.MethodDelete
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"ipmanlk/ani2mal/anilist"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/mal"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+)
+
+// Clients and access tokens for both services
+type session struct {
+	anilist     *anilist.Client
+	mal         *mal.Client
+	anilistCode string
+	malCode     string
+}
+
+func newSession(ctx context.Context) *session {
+	s, err := openSession(ctx)
+	if err != nil {
+		utils.Fatal("Failed to start the session", "error", err)
+	}
+	return s
+}
+
+// creates both clients from the current settings and fetches their access tokens,
+// refreshing tokens that are about to expire
+func openSession(ctx context.Context) (*session, error) {
+	appConfig := config.GetAppConfig()
+	settings := appConfig.GetSettings()
+	store := appConfig.GetCache()
+
+	anilistClient, err := anilist.NewClient(settings, store)
+	if err != nil {
+		return nil, err
+	}
+
+	malClient, err := mal.NewClient(settings, store)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &session{anilist: anilistClient, mal: malClient}
+
+	s.anilistCode, err = s.anilist.GetAccessCode(ctx)
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to get the Anilist access token",
+			Err:     err,
+		}
+	}
+
+	s.malCode, err = s.mal.GetAccessCode(ctx)
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to get the MAL access token",
+			Err:     err,
+		}
+	}
+
+	return s, nil
+}
+
+// takes the profile lock so only one process changes MAL at a time
+func lockProfile() *config.Lock {
+	lock, err := config.GetAppConfig().AcquireLock()
+	if err != nil {
+		utils.Fatal("Failed to lock the profile", "error", err)
+	}
+	return lock
+}
+
+func newAnilistClient() *anilist.Client {
+	client, err := anilist.NewClient(config.GetAppConfig().GetSettings(), config.GetAppConfig().GetCache())
+	if err != nil {
+		utils.Fatal("Failed to create the Anilist client", "error", err)
+	}
+	return client
+}
+
+func newMalClient() *mal.Client {
+	client, err := mal.NewClient(config.GetAppConfig().GetSettings(), config.GetAppConfig().GetCache())
+	if err != nil {
+		utils.Fatal("Failed to create the MAL client", "error", err)
+	}
+	return client
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/utils"
+	"os"
+)
+
+const cacheUsage = `Usage:
+  ani2mal cache clear
+`
+
+func runCache(args []string) {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, cacheUsage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "clear":
+		if err := config.GetAppConfig().ClearCache(); err != nil {
+			utils.Fatal("Failed to clear the response cache", "error", err)
+		}
+		fmt.Println("Response cache cleared.")
+
+	default:
+		fmt.Fprint(os.Stderr, cacheUsage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/metrics"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Defaults used when neither a flag nor the daemon settings set a value
+const (
+	defaultDaemonInterval   = time.Hour
+	defaultDaemonJitter     = 5 * time.Minute
+	defaultDaemonMaxBackoff = time.Hour
+)
+
+// First retry delay after a failed sync, doubled for every failure in a row
+const daemonRetryDelay = time.Minute
+
+type daemonOptions struct {
+	interval   time.Duration
+	jitter     time.Duration
+	maxBackoff time.Duration
+}
+
+// syncs on an interval until interrupted. SIGHUP reloads the settings
+func runDaemon(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	interval := flags.Duration("interval", 0, "time between syncs (default 1h, or daemon.interval_minutes from settings)")
+	jitter := flags.Duration("jitter", 0, "random delay added to each interval (default 5m, or daemon.jitter_minutes from settings)")
+	maxBackoff := flags.Duration("max-backoff", 0, "longest delay between retries after failed syncs (default 1h, or daemon.max_backoff_minutes from settings)")
+	autoMatch := flags.Bool("auto-match", false, "accept high confidence matches for entries that have no MAL ID")
+	metricsAddr := flags.String("metrics-addr", "", "serve Prometheus metrics on this address, for example :9090")
+	flags.Parse(args)
+
+	if *metricsAddr != "" {
+		server := serveMetrics(*metricsAddr)
+		defer server.Close()
+	}
+
+	// a broken settings file fails at startup, later it only keeps the last good settings
+	appConfig := config.GetAppConfig()
+	settings, err := appConfig.LoadSettings()
+	if err != nil {
+		utils.Fatal("Failed to load the settings", "error", err)
+	}
+	appConfig.PinSettings(settings)
+
+	overrides := daemonOptions{interval: *interval, jitter: *jitter, maxBackoff: *maxBackoff}
+	options := loadDaemonOptions(overrides)
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	slog.Info("Daemon started", "interval", options.interval, "jitter", options.jitter, "pid", os.Getpid())
+
+	failures := 0
+
+	for {
+		reloadSettings()
+
+		startedAt := time.Now()
+		result, err := daemonSync(ctx, *autoMatch)
+		recordSyncMetrics(result, err, time.Since(startedAt))
+		if !errors.Is(err, config.ErrLocked) {
+			notifySync(ctx, result, err)
+		}
+
+		if ctx.Err() != nil {
+			slog.Info("Daemon stopped")
+			return
+		}
+
+		delay := options.nextDelay()
+
+		switch {
+		case errors.Is(err, config.ErrLocked):
+			slog.Warn("Skipped sync, another sync is running for this profile")
+		case err != nil:
+			failures++
+			delay = options.backoff(failures)
+			slog.Error("Sync failed", "error", err, "failures", failures)
+		default:
+			failures = 0
+		}
+
+		slog.Info("Next sync scheduled", "at", time.Now().Add(delay).Format(time.DateTime))
+
+		timer := time.NewTimer(delay)
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				slog.Info("Daemon stopped")
+				return
+			case <-reload:
+				if reloadSettings() {
+					options = loadDaemonOptions(overrides)
+					slog.Info("Reloaded settings", "interval", options.interval, "jitter", options.jitter)
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+}
+
+// reads the settings file again for the next sync. A broken file is logged and the
+// last good settings stay in use, so a typo doesn't stop the daemon
+func reloadSettings() bool {
+	appConfig := config.GetAppConfig()
+
+	settings, err := appConfig.LoadSettings()
+	if err != nil {
+		slog.Error("Failed to reload the settings, keeping the last good settings", "error", err)
+		return false
+	}
+
+	appConfig.PinSettings(settings)
+	return true
+}
+
+// a single unattended sync. Clients and tokens are created for every run so
+// settings changes are picked up and tokens are refreshed before they expire
+func daemonSync(ctx context.Context, autoMatch bool) (*models.SyncResult, error) {
+	lock, err := config.GetAppConfig().AcquireLock()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	s, err := openSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := syncLibraries(ctx, s, syncOptions{autoMatch: autoMatch})
+	if err != nil {
+		return nil, err
+	}
+	logSyncResult(result)
+
+	if result.IsPartial() && !result.Interrupted {
+		return result, &models.AppError{
+			Message: "Some changes were not applied to MAL",
+		}
+	}
+
+	return result, nil
+}
+
+func recordSyncMetrics(result *models.SyncResult, err error, duration time.Duration) {
+	switch {
+	case errors.Is(err, config.ErrLocked):
+		metrics.SyncRuns.Inc("skipped")
+		return
+	case result == nil:
+		metrics.SyncRuns.Inc("failed")
+	case result.IsPartial():
+		metrics.SyncRuns.Inc("partial")
+	default:
+		metrics.SyncRuns.Inc("success")
+		metrics.LastSuccessfulSync.Set(float64(result.FinishedAt.Unix()))
+	}
+
+	metrics.SyncDuration.Observe(duration.Seconds())
+
+	if result == nil {
+		return
+	}
+	for _, entry := range result.Entries {
+		metrics.SyncEntries.Inc(string(entry.Op), string(entry.Media.Type), string(entry.Outcome))
+	}
+}
+
+// the listener is opened right away so a bad address fails at startup rather than in the background
+func serveMetrics(addr string) *http.Server {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		utils.Fatal("Failed to listen for metrics requests", "addr", addr, "error", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Metrics server stopped", "error", err)
+		}
+	}()
+
+	slog.Info("Serving metrics", "addr", listener.Addr().String())
+
+	return server
+}
+
+func logSyncResult(result *models.SyncResult) {
+	slog.Info("Sync finished",
+		"added", result.Count(models.SyncOpAdd, models.SyncOutcomeApplied),
+		"updated", result.Count(models.SyncOpUpdate, models.SyncOutcomeApplied),
+		"deleted", result.Count(models.SyncOpDelete, models.SyncOutcomeApplied),
+		"failed", result.Count("", models.SyncOutcomeFailed),
+		"duration", result.FinishedAt.Sub(result.StartedAt).Round(time.Millisecond),
+	)
+}
+
+// flags take precedence over settings, settings over the defaults
+func loadDaemonOptions(overrides daemonOptions) daemonOptions {
+	settings := config.GetAppConfig().GetSettings().Daemon
+
+	return daemonOptions{
+		interval:   firstDuration(overrides.interval, minutes(settings.IntervalMinutes), defaultDaemonInterval),
+		jitter:     firstDuration(overrides.jitter, minutes(settings.JitterMinutes), defaultDaemonJitter),
+		maxBackoff: firstDuration(overrides.maxBackoff, minutes(settings.MaxBackoffMinutes), defaultDaemonMaxBackoff),
+	}
+}
+
+func (o daemonOptions) nextDelay() time.Duration {
+	delay := o.interval
+	if o.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(o.jitter)))
+	}
+	return delay
+}
+
+// doubles the retry delay for every failure in a row, capped at maxBackoff
+func (o daemonOptions) backoff(failures int) time.Duration {
+	delay := daemonRetryDelay
+	for i := 1; i < failures && delay < o.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > o.maxBackoff {
+		delay = o.maxBackoff
+	}
+
+	return delay
+}
+
+func minutes(value int) time.Duration {
+	return time.Duration(value) * time.Minute
+}
+
+func firstDuration(durations ...time.Duration) time.Duration {
+	for _, duration := range durations {
+		if duration > 0 {
+			return duration
+		}
+	}
+	return 0
+}
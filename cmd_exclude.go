@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/models"
+	"os"
+	"sort"
+)
+
+const excludeUsage = `Usage:
+  ani2mal exclude add <anime|manga>:<mal-id>
+  ani2mal exclude list
+  ani2mal exclude remove <anime|manga>:<mal-id>
+`
+
+func runExclude(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, excludeUsage)
+		os.Exit(2)
+	}
+
+	appConfig := config.GetAppConfig()
+	exclusions := appConfig.GetExclusions()
+
+	switch args[0] {
+	case "add":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, excludeUsage)
+			os.Exit(2)
+		}
+
+		key := parseExclusionKey(args[1])
+		exclusions[key] = models.Exclusion{Type: key.Type, ID: key.ID}
+		appConfig.SaveExclusions(exclusions)
+		fmt.Printf("Excluded %s from syncs\n", key)
+
+	case "list":
+		if len(exclusions) == 0 {
+			fmt.Println("No entries are excluded.")
+			return
+		}
+
+		keys := make([]models.MediaKey, 0, len(exclusions))
+		for key := range exclusions {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, key := range keys {
+			if title := exclusions[key].Title; title != "" {
+				fmt.Printf("%s %s\n", key, title)
+			} else {
+				fmt.Println(key)
+			}
+		}
+
+	case "remove":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, excludeUsage)
+			os.Exit(2)
+		}
+
+		key := parseExclusionKey(args[1])
+		if _, ok := exclusions[key]; !ok {
+			fmt.Fprintf(os.Stderr, "%s is not excluded\n", key)
+			os.Exit(1)
+		}
+
+		delete(exclusions, key)
+		appConfig.SaveExclusions(exclusions)
+		// the entry may have changed on Anilist while it was excluded
+		requestFullSync()
+		fmt.Printf("Removed exclusion %s\n", key)
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown exclude command: %s\n\n%s", args[0], excludeUsage)
+		os.Exit(2)
+	}
+}
+
+func parseExclusionKey(arg string) models.MediaKey {
+	var key models.MediaKey
+	if err := key.UnmarshalText([]byte(arg)); err != nil || key.ID <= 0 || (key.Type != models.MediaTypeAnime && key.Type != models.MediaTypeManga) {
+		fmt.Fprintf(os.Stderr, "Invalid entry %q, expected <anime|manga>:<mal-id>\n", arg)
+		os.Exit(2)
+	}
+	return key
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"ipmanlk/ani2mal/export"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+	"os"
+	"strings"
+)
+
+func runExport(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	source := flags.String("source", "anilist", "library to export: anilist or mal")
+	format := flags.String("format", export.FormatCSV, "output format: csv, json or markdown")
+	columnList := flags.String("columns", strings.Join(export.DefaultColumns, ","), "comma separated columns: "+strings.Join(export.ColumnNames(), ", "))
+	mediaType := flags.String("type", "", "only export anime or manga")
+	statusList := flags.String("status", "", "comma separated statuses to export, for example current,completed")
+	minScore := flags.Int("min-score", 0, "only export entries scored at least this")
+	maxScore := flags.Int("max-score", 0, "only export entries scored at most this")
+	output := flags.String("o", "", "write to this file instead of stdout")
+	flags.Parse(args)
+
+	if !export.IsValidFormat(*format) {
+		fmt.Fprintf(os.Stderr, "Invalid export format: %s\n", *format)
+		os.Exit(2)
+	}
+
+	filter, err := parseExportFilter(*mediaType, *statusList, *minScore, *maxScore)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	columns := splitList(*columnList)
+	if err := export.ValidateColumns(columns); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var data *models.SourceData
+
+	switch *source {
+	case "anilist":
+		client := newAnilistClient()
+		code, err := client.GetAccessCode(ctx)
+		if err != nil {
+			utils.Fatal("Failed to get the Anilist access token", "error", err)
+		}
+		data, err = fetchAnilistData(ctx, client, code)
+		if err != nil {
+			utils.Fatal("Failed to fetch the Anilist library", "error", err)
+		}
+		recordHistory("anilist", data, false)
+	case "mal":
+		client := newMalClient()
+		code, err := client.GetAccessCode(ctx)
+		if err != nil {
+			utils.Fatal("Failed to get the MAL access token", "error", err)
+		}
+		data, err = client.GetUserData(ctx, code)
+		if err != nil {
+			utils.Fatal("Failed to fetch the MAL library", "error", err)
+		}
+		recordHistory("mal", data, false)
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid source: %s, expected anilist or mal\n", *source)
+		os.Exit(2)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			utils.Fatal("Failed to create the export file", "path", *output, "error", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	entries := export.Entries(data, filter)
+
+	if err := export.Write(out, *format, entries, columns); err != nil {
+		utils.Fatal("Failed to export the library", "error", err)
+	}
+}
+
+func parseExportFilter(mediaType, statusList string, minScore, maxScore int) (export.Filter, error) {
+	filter := export.Filter{MinScore: minScore, MaxScore: maxScore}
+
+	switch models.MediaType(mediaType) {
+	case "", models.MediaTypeAnime, models.MediaTypeManga:
+		filter.Type = models.MediaType(mediaType)
+	default:
+		return filter, fmt.Errorf("Invalid type: %s, expected anime or manga", mediaType)
+	}
+
+	for _, status := range splitList(statusList) {
+		valid := false
+		for _, known := range knownStatuses {
+			if status == string(known) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return filter, fmt.Errorf("Invalid status: %s", status)
+		}
+		filter.Statuses = append(filter.Statuses, models.MediaStatus(status))
+	}
+
+	if minScore < 0 || minScore > 10 || maxScore < 0 || maxScore > 10 {
+		return filter, fmt.Errorf("Scores must be between 0 and 10")
+	}
+
+	return filter, nil
+}
+
+// splits a comma separated flag value, ignoring empty items
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/history"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const historyUsage = `Usage:
+  ani2mal history diff [-since 7d] [-service anilist|mal] [-status completed]
+
+Every fetched library is recorded, -since takes a number of days (7d), weeks (2w),
+a duration (36h) or a date (2024-01-31)
+`
+
+func runHistory(args []string) {
+	if len(args) == 0 || args[0] != "diff" {
+		fmt.Fprint(os.Stderr, historyUsage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("history diff", flag.ExitOnError)
+	sinceArg := flags.String("since", "7d", "only show changes recorded after this")
+	service := flags.String("service", "", "only show changes of anilist or mal")
+	status := flags.String("status", "", "only show changes to this status, for example completed")
+	flags.Parse(args[1:])
+
+	since, err := parseSince(*sinceArg, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	services := []string{"anilist", "mal"}
+	switch *service {
+	case "":
+	case "anilist", "mal":
+		services = []string{*service}
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid service: %s, expected anilist or mal\n", *service)
+		os.Exit(2)
+	}
+
+	store := config.GetAppConfig().GetHistory()
+	names := map[string]string{"anilist": "Anilist", "mal": "MAL"}
+
+	for i, name := range services {
+		changes, err := store.Changes(name, since)
+		if err != nil {
+			utils.Fatal("Failed to read the library history", "service", name, "error", err)
+		}
+
+		if *status != "" {
+			changes = filterStatusChanges(changes, models.MediaStatus(*status))
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s changes since %s:\n", names[name], since.Format(time.DateTime))
+
+		if len(changes) == 0 {
+			fmt.Println("  No changes recorded.")
+			continue
+		}
+
+		for _, change := range changes {
+			fmt.Printf("  %s  [%s] %s: %s\n", change.At.Format("2006-01-02 15:04"), change.Media.Type, change.Media.Title, describeChange(change))
+		}
+	}
+}
+
+// keeps the changes that moved an entry to status
+func filterStatusChanges(changes []history.Change, status models.MediaStatus) []history.Change {
+	filtered := make([]history.Change, 0)
+
+	for _, change := range changes {
+		for _, field := range change.Fields {
+			if field.Field == "status" && field.To == string(status) {
+				filtered = append(filtered, change)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+func describeChange(change history.Change) string {
+	switch change.Kind {
+	case history.ChangeAdded:
+		return fmt.Sprintf("added as %s, score %d, progress %d", change.Media.Status, change.Media.Score, change.Media.Progress)
+	case history.ChangeRemoved:
+		return "removed"
+	}
+
+	parts := make([]string, 0, len(change.Fields))
+	for _, field := range change.Fields {
+		part := fmt.Sprintf("%s %s -> %s", field.Field, field.From, field.To)
+
+		if field.Field == "progress" {
+			from, _ := strconv.Atoi(field.From)
+			to, _ := strconv.Atoi(field.To)
+			part += fmt.Sprintf(" (%+d)", to-from)
+		}
+
+		parts = append(parts, part)
+	}
+
+	if len(parts) == 0 {
+		return "rewatch changed"
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// accepts 7d, 2w, Go durations such as 36h or a date
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if date, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return date, nil
+	}
+
+	if count, ok := strings.CutSuffix(value, "d"); ok {
+		if days, err := strconv.Atoi(count); err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if count, ok := strings.CutSuffix(value, "w"); ok {
+		if weeks, err := strconv.Atoi(count); err == nil && weeks >= 0 {
+			return now.AddDate(0, 0, -7*weeks), nil
+		}
+	}
+
+	if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+		return now.Add(-duration), nil
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid -since value %q, expected for example 7d, 2w, 36h or 2024-01-31", value)
+}
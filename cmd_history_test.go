@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "7d", want: time.Date(2024, 3, 3, 12, 0, 0, 0, time.Local)},
+		{value: "0d", want: now},
+		{value: "2w", want: time.Date(2024, 2, 25, 12, 0, 0, 0, time.Local)},
+		{value: "36h", want: now.Add(-36 * time.Hour)},
+		{value: "90m", want: now.Add(-90 * time.Minute)},
+		{value: "2024-01-31", want: time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local)},
+		{value: "", wantErr: true},
+		{value: "-3d", wantErr: true},
+		{value: "-1h", wantErr: true},
+		{value: "week", wantErr: true},
+		{value: "2024-13-01", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseSince(tt.value, now)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseSince(%q) = %v, want an error", tt.value, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseSince(%q) error = %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseSince(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+const loginUsage = `Usage: ani2mal login <anilist|mal>
+`
+
+func runLogin(ctx context.Context, args []string) {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, loginUsage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "anilist":
+		newAnilistClient().PerformAuth(ctx)
+	case "mal":
+		newMalClient().PerformAuth(ctx)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown service: %s\n\n%s", args[0], loginUsage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/models"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const mappingUsage = `Usage:
+  ani2mal mapping add <anilist-id> <mal-id|ignore>
+  ani2mal mapping list
+  ani2mal mapping remove <anilist-id>
+`
+
+func runMapping(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, mappingUsage)
+		os.Exit(2)
+	}
+
+	appConfig := config.GetAppConfig()
+	mappings := appConfig.GetIdMappings()
+
+	switch args[0] {
+	case "add":
+		if len(args) != 3 {
+			fmt.Fprint(os.Stderr, mappingUsage)
+			os.Exit(2)
+		}
+
+		mapping, err := parseIdMapping(args[1], args[2])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		mappings[mapping.AnilistID] = mapping
+		appConfig.SaveIdMappings(mappings)
+		requestFullSync()
+		fmt.Printf("Added mapping %s\n", describeIdMapping(mapping))
+
+	case "list":
+		if len(mappings) == 0 {
+			fmt.Println("No ID mappings configured.")
+			return
+		}
+
+		anilistIds := make([]int, 0, len(mappings))
+		for anilistId := range mappings {
+			anilistIds = append(anilistIds, anilistId)
+		}
+		sort.Ints(anilistIds)
+
+		for _, anilistId := range anilistIds {
+			fmt.Println(describeIdMapping(mappings[anilistId]))
+		}
+
+	case "remove":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, mappingUsage)
+			os.Exit(2)
+		}
+
+		anilistId, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid Anilist ID: %s\n", args[1])
+			os.Exit(2)
+		}
+
+		mapping, ok := mappings[anilistId]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "No mapping exists for Anilist ID %d\n", anilistId)
+			os.Exit(1)
+		}
+
+		delete(mappings, anilistId)
+		appConfig.SaveIdMappings(mappings)
+		requestFullSync()
+		fmt.Printf("Removed mapping %s\n", describeIdMapping(mapping))
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown mapping command: %s\n\n%s", args[0], mappingUsage)
+		os.Exit(2)
+	}
+}
+
+func parseIdMapping(anilistArg, malArg string) (models.IdMapping, error) {
+	anilistId, err := strconv.Atoi(anilistArg)
+	if err != nil || anilistId <= 0 {
+		return models.IdMapping{}, fmt.Errorf("Invalid Anilist ID: %s", anilistArg)
+	}
+
+	if malArg == "ignore" {
+		return models.IdMapping{AnilistID: anilistId, Ignore: true}, nil
+	}
+
+	malId, err := strconv.Atoi(malArg)
+	if err != nil || malId <= 0 {
+		return models.IdMapping{}, fmt.Errorf("Invalid MAL ID: %s (expected a number or \"ignore\")", malArg)
+	}
+
+	return models.IdMapping{AnilistID: anilistId, MalID: malId}, nil
+}
+
+func describeIdMapping(mapping models.IdMapping) string {
+	if mapping.Ignore {
+		return fmt.Sprintf("%d -> ignore", mapping.AnilistID)
+	}
+	return fmt.Sprintf("%d -> %d", mapping.AnilistID, mapping.MalID)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"ipmanlk/ani2mal/cache"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/mal"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/report"
+	"ipmanlk/ani2mal/utils"
+	"os"
+	"strings"
+	"time"
+)
+
+// restores the backup taken before the last change to MAL
+func runUndo(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("undo", flag.ExitOnError)
+	yes := flags.Bool("yes", false, "apply without asking for confirmation")
+	reportFormat := flags.String("report", report.FormatText, "report format: text, json or markdown")
+	flags.Parse(args)
+
+	backupNames := config.GetAppConfig().ListBackups()
+	if len(backupNames) == 0 {
+		fmt.Println("There is nothing to undo, no backups found.")
+		return
+	}
+
+	restoreBackup(ctx, backupNames[0], *yes, *reportFormat)
+}
+
+func runRestore(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	yes := flags.Bool("yes", false, "apply without asking for confirmation")
+	reportFormat := flags.String("report", report.FormatText, "report format: text, json or markdown")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ani2mal restore [options] [backup]\n\nLists backups when no backup is given. Start dates, finish dates\nand notes set after the backup was taken are kept, MAL can't clear them.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		printBackups()
+		return
+	}
+
+	restoreBackup(ctx, flags.Arg(0), *yes, *reportFormat)
+}
+
+func printBackups() {
+	appConfig := config.GetAppConfig()
+	backupNames := appConfig.ListBackups()
+
+	if len(backupNames) == 0 {
+		fmt.Println("No backups found.")
+		return
+	}
+
+	for _, name := range backupNames {
+		backup, err := appConfig.GetBackup(name)
+		if err != nil {
+			fmt.Printf("%s (unreadable: %v)\n", name, err)
+			continue
+		}
+
+		createdAt := time.Unix(backup.CreatedAt, 0).Format(time.DateTime)
+		fmt.Printf("%s  %s  %d anime, %d manga\n", name, createdAt, len(backup.Data.Anime), len(backup.Data.Manga))
+	}
+}
+
+func restoreBackup(ctx context.Context, name string, yes bool, reportFormat string) {
+	if !report.IsValidFormat(reportFormat) {
+		fmt.Fprintf(os.Stderr, "Invalid report format: %s\n", reportFormat)
+		os.Exit(2)
+	}
+
+	lock := lockProfile()
+	defer lock.Release()
+
+	backup, err := config.GetAppConfig().GetBackup(name)
+	if err != nil {
+		utils.Fatal("Failed to load the backup", "backup", name, "error", err)
+	}
+
+	malClient := newMalClient()
+
+	malCode, err := malClient.GetAccessCode(ctx)
+	if err != nil {
+		utils.Fatal("Failed to get the MAL access token", "error", err)
+	}
+
+	// the inverse plan has to be computed from the list as it is right now
+	malData, err := malClient.GetUserData(cache.Refresh(ctx), malCode)
+	if err != nil {
+		utils.Fatal("Failed to fetch the MAL library", "error", err)
+	}
+
+	plan := mal.PlanRestore(backup, malData)
+	if len(plan) == 0 {
+		fmt.Printf("MAL already matches backup %s.\n", backup.Name)
+		return
+	}
+
+	printPlanSummary(plan)
+
+	if !yes {
+		fmt.Fprintf(os.Stderr, "Restore MAL to backup %s? [y/N]: ", backup.Name)
+		if answer := strings.ToLower(strings.TrimSpace(utils.GetStrInput())); answer != "y" && answer != "yes" {
+			fmt.Println("Restore cancelled.")
+			return
+		}
+	}
+
+	// the current list is backed up first, so a restore can be undone as well
+	result, err := malClient.ApplyPlan(ctx, malCode, plan, malData)
+	if err != nil {
+		utils.Fatal("Failed to restore the backup", "error", err)
+	}
+	finishSync(result, reportFormat, lock)
+}
+
+// the summary goes to stderr so it never mixes with a machine readable report
+func printPlanSummary(plan []models.SyncOp) {
+	for _, op := range plan {
+		media := op.Media
+
+		switch op.Kind {
+		case models.SyncOpAdd:
+			fmt.Fprintf(os.Stderr, "  add     [%s] %s (%s, %d/%d, score %d)\n", media.Type, media.Title, media.Status, media.Progress, media.Length, media.Score)
+		case models.SyncOpUpdate:
+			fmt.Fprintf(os.Stderr, "  update  [%s] %s (%s, %d/%d, score %d)\n", media.Type, media.Title, media.Status, media.Progress, media.Length, media.Score)
+		case models.SyncOpDelete:
+			fmt.Fprintf(os.Stderr, "  delete  [%s] %s\n", media.Type, media.Title)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"ipmanlk/ani2mal/cache"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/dashboard"
+	"ipmanlk/ani2mal/mal"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// serves the web UI until interrupted. Only localhost is listened on by default
+// since anyone who can open the page can change the MAL list
+func runServe(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "address to serve the web UI on")
+	flags.Parse(args)
+
+	server, err := dashboard.New(ctx, *addr, loadDashboardData, planDashboardSync, applyDashboardPlan)
+	if err != nil {
+		utils.Fatal("Failed to start the web UI", "error", err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		utils.Fatal("Failed to listen for web UI requests", "addr", *addr, "error", err)
+	}
+
+	server.Reload(false)
+
+	httpServer := &http.Server{Handler: server.Handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving the web UI", "url", "http://"+listener.Addr().String())
+
+	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		utils.Fatal("Web UI server stopped", "error", err)
+	}
+}
+
+// a new session is opened for every load and apply so tokens are refreshed while the UI stays open
+func loadDashboardData(ctx context.Context, refresh bool) (*models.SourceData, *models.SourceData, error) {
+	s, err := openSession(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if refresh {
+		ctx = cache.Refresh(ctx)
+	}
+
+	return fetchLibraries(ctx, s, 0, false)
+}
+
+func planDashboardSync(anilistData, malData *models.SourceData) []models.SyncOp {
+	appConfig := config.GetAppConfig()
+	return mal.PlanSync(anilistData, malData, appConfig.GetSettings().Sync.Policies, appConfig.GetExclusions())
+}
+
+// the plan was made from the MAL list shown on the page, changes whose MAL entry changed since are
+// skipped. The rest is applied and recorded the same way as a full sync
+func applyDashboardPlan(ctx context.Context, plan []models.SyncOp, anilistData *models.SourceData) (*models.SyncResult, error) {
+	lock, err := config.GetAppConfig().AcquireLock()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	s, err := openSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	malData, err := s.mal.GetUserData(cache.Refresh(ctx), s.malCode)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, stale := mal.RemoveStale(plan, malData)
+
+	if mal.DiscardUnfinishedSync() {
+		slog.Warn("The previous sync did not finish, this sync replaces its remaining changes")
+	}
+
+	result, err := s.mal.ApplyPlan(ctx, s.malCode, plan, malData)
+	if err != nil {
+		notifySync(ctx, nil, err)
+		return nil, err
+	}
+
+	for _, op := range stale {
+		slog.Warn("Skipped change, the MAL entry changed after the page was loaded", "op", op.Kind, "type", op.Media.Type, "id", op.Media.ID, "title", op.Media.Title)
+		result.Entries = append(result.Entries, models.SyncEntryResult{
+			Op:      op.Kind,
+			Media:   op.Media,
+			Outcome: models.SyncOutcomeNotApplied,
+			Error:   "the MAL entry changed after the page was loaded",
+		})
+	}
+	logSyncResult(result)
+
+	saveSyncState(anilistData, result, true)
+	notifySync(ctx, result, nil)
+
+	return result, nil
+}
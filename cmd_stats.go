@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/stats"
+	"ipmanlk/ani2mal/utils"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Width of the longest bar in the score histograms
+const histogramWidth = 20
+
+func runStats(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the statistics as JSON")
+	flags.Parse(args)
+
+	s := newSession(ctx)
+
+	anilistData, malData, err := fetchLibraries(ctx, s, 0, true)
+	if err != nil {
+		utils.Fatal("Failed to compute the statistics", "error", err)
+	}
+
+	durations := stats.Durations(anilistData)
+	anilistStats := stats.Compute(anilistData, durations)
+	malStats := stats.Compute(malData, durations)
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", " ")
+		err := encoder.Encode(map[string]*stats.Stats{"anilist": anilistStats, "mal": malStats})
+		if err != nil {
+			utils.Fatal("Failed to write the statistics", "error", err)
+		}
+		return
+	}
+
+	differs := writeTypeStats(os.Stdout, anilistStats.Anime, malStats.Anime)
+	fmt.Println()
+	differs = writeTypeStats(os.Stdout, anilistStats.Manga, malStats.Manga) || differs
+
+	fmt.Println()
+	if differs {
+		fmt.Println("* Anilist and MAL differ")
+	}
+	if len(anilistData.Unmapped) > 0 {
+		fmt.Printf("%d Anilist entries without a MAL ID are not counted\n", len(anilistData.Unmapped))
+	}
+	if unknown := anilistStats.Anime.UnknownDuration; unknown > 0 {
+		fmt.Printf("Watch time leaves out %d Anilist entries with an unknown episode length\n", unknown)
+	}
+}
+
+// prints both libraries side by side and reports whether any value differs
+func writeTypeStats(out io.Writer, anilist, mal stats.TypeStats) bool {
+	differs := false
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+
+	row := func(label, anilistValue, malValue string) {
+		marker := ""
+		if anilistValue != malValue {
+			marker = "*"
+			differs = true
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", label, anilistValue, malValue, marker)
+	}
+
+	fmt.Fprintf(w, "%s\tAnilist\tMAL\t\n", strings.ToUpper(string(anilist.Type[:1]))+string(anilist.Type[1:]))
+	row("Entries", strconv.Itoa(anilist.Total), strconv.Itoa(mal.Total))
+	for _, status := range stats.Statuses {
+		row(string(status), strconv.Itoa(anilist.Status[status]), strconv.Itoa(mal.Status[status]))
+	}
+	row("Scored", strconv.Itoa(anilist.Scored), strconv.Itoa(mal.Scored))
+	row("Mean score", formatScore(anilist.MeanScore), formatScore(mal.MeanScore))
+	row("Median score", formatScore(anilist.MedianScore), formatScore(mal.MedianScore))
+
+	if anilist.Type == models.MediaTypeAnime {
+		row("Episodes watched", strconv.Itoa(anilist.Progress), strconv.Itoa(mal.Progress))
+		row("Watch time", formatMinutes(anilist.WatchMinutes), formatMinutes(mal.WatchMinutes))
+	} else {
+		row("Chapters read", strconv.Itoa(anilist.Progress), strconv.Itoa(mal.Progress))
+	}
+
+	w.Flush()
+
+	fmt.Fprintln(out, "\n  Score distribution")
+	w = tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+
+	largest := 0
+	for score := 0; score <= 10; score++ {
+		largest = max(largest, anilist.ScoreHistogram[score], mal.ScoreHistogram[score])
+	}
+
+	fmt.Fprintln(w, "  \tAnilist\tMAL\t")
+	for score := 10; score >= 0; score-- {
+		label := strconv.Itoa(score)
+		if score == 0 {
+			label = "none"
+		}
+		row(label, formatBar(anilist.ScoreHistogram[score], largest), formatBar(mal.ScoreHistogram[score], largest))
+	}
+
+	w.Flush()
+
+	return differs
+}
+
+func formatScore(score float64) string {
+	if score == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(score, 'f', 2, 64)
+}
+
+func formatMinutes(total int) string {
+	days, hours, minutes := total/(24*60), total/60%24, total%60
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+func formatBar(count, largest int) string {
+	if count == 0 {
+		return "0"
+	}
+
+	width := 1
+	if largest > 0 {
+		width = max(1, count*histogramWidth/largest)
+	}
+	return strings.Repeat("#", width) + " " + strconv.Itoa(count)
+}
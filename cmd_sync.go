@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"ipmanlk/ani2mal/anilist"
+	"ipmanlk/ani2mal/cache"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/mal"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/notify"
+	"ipmanlk/ani2mal/report"
+	"ipmanlk/ani2mal/utils"
+	"log/slog"
+	"os"
+	"time"
+)
+
+func runSync(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	resolve := flags.Bool("resolve", false, "interactively match Anilist entries that have no MAL ID")
+	autoMatch := flags.Bool("auto-match", false, "accept high confidence matches for entries that have no MAL ID")
+	resume := flags.Bool("resume", false, "continue the last sync that did not finish")
+	full := flags.Bool("full", false, "compare the whole libraries instead of only the Anilist entries changed since the last sync")
+	review := flags.Bool("review", false, "walk through every change and choose which ones to apply")
+	reportFormat := flags.String("report", report.FormatText, "report format: text, json or markdown")
+	flags.Parse(args)
+
+	if !report.IsValidFormat(*reportFormat) {
+		fmt.Fprintf(os.Stderr, "Invalid report format: %s\n", *reportFormat)
+		os.Exit(2)
+	}
+
+	lock := lockProfile()
+	defer lock.Release()
+
+	s := newSession(ctx)
+
+	if *resume {
+		resumeSync(ctx, s, *reportFormat, lock)
+		return
+	}
+
+	options := syncOptions{full: *full, resolve: *resolve, autoMatch: *autoMatch, review: *review}
+	result, err := syncLibraries(ctx, s, options)
+	notifySync(ctx, result, err)
+	if err != nil {
+		utils.Fatal("Sync failed", "error", err)
+	}
+
+	finishSync(result, *reportFormat, lock)
+}
+
+// Time between full syncs when the settings don't set one
+const defaultFullSyncInterval = 24 * time.Hour
+
+type syncOptions struct {
+	// compare the whole libraries even when a full sync is not due
+	full bool
+	// ask the user to match unmapped entries
+	resolve   bool
+	autoMatch bool
+	// ask the user to approve every change
+	review bool
+}
+
+// fetches both libraries, resolves unmapped entries and applies the changes to MAL.
+// Between full syncs only the Anilist entries changed since the last sync are compared
+func syncLibraries(ctx context.Context, s *session, options syncOptions) (*models.SyncResult, error) {
+	appConfig := config.GetAppConfig()
+	state := appConfig.GetSyncState()
+	full := options.full || isFullSyncDue(state)
+
+	var since int64
+	if !full {
+		since = state.AnilistUpdatedAt
+	}
+
+	anilistData, malData, err := fetchLibraries(ctx, s, since, false)
+	if !full && errors.Is(err, anilist.ErrTooManyChanges) {
+		slog.Info("Too many Anilist entries changed since the last sync, running a full sync")
+		full = true
+		anilistData, malData, err = fetchLibraries(ctx, s, 0, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(anilistData.Unmapped) > 0 {
+		if options.resolve || options.autoMatch {
+			resolveUnmapped(ctx, s, anilistData, options.resolve, options.autoMatch)
+		}
+		printUnmappedNotice(anilistData)
+	}
+
+	var plan []models.SyncOp
+	if full {
+		plan = mal.PlanSync(anilistData, malData, appConfig.GetSettings().Sync.Policies, appConfig.GetExclusions())
+	} else {
+		slog.Info("Syncing Anilist entries changed since the last sync", "since", time.Unix(since, 0).Format(time.DateTime), "changed", len(anilistData.MediaMap))
+		plan = mal.PlanIncrementalSync(anilistData, malData, appConfig.GetSettings().Sync.Policies, appConfig.GetExclusions())
+	}
+
+	if options.review {
+		plan = reviewPlan(plan)
+	}
+
+	// a full sync plans every change again, including the ones an unfinished sync did not get to
+	if full && mal.DiscardUnfinishedSync() {
+		slog.Warn("The previous sync did not finish, this sync replaces its remaining changes")
+	}
+
+	result, err := s.mal.ApplyPlan(ctx, s.malCode, plan, malData)
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to apply the changes to MAL",
+			Err:     err,
+		}
+	}
+
+	saveSyncState(anilistData, result, full)
+
+	return result, nil
+}
+
+// the marks only move after a clean sync so failed entries are compared again next time.
+// Changes skipped during a review are offered again by the next full sync
+func saveSyncState(anilistData *models.SourceData, result *models.SyncResult, full bool) {
+	if result.IsPartial() {
+		return
+	}
+
+	appConfig := config.GetAppConfig()
+	state := appConfig.GetSyncState()
+
+	if newest := getNewestUpdate(anilistData); newest > state.AnilistUpdatedAt {
+		state.AnilistUpdatedAt = newest
+	}
+	if full {
+		state.LastFullSyncAt = result.StartedAt.Unix()
+	}
+	appConfig.SaveSyncState(state)
+}
+
+func isFullSyncDue(state *models.SyncState) bool {
+	if state.AnilistUpdatedAt == 0 {
+		return true
+	}
+
+	// the remaining changes of an unfinished sync may include deletes, only a full sync plans those
+	if journal := config.GetAppConfig().GetSyncJournal(); journal != nil && !journal.IsComplete() {
+		return true
+	}
+
+	interval := defaultFullSyncInterval
+	if hours := config.GetAppConfig().GetSettings().Sync.FullSyncHours; hours > 0 {
+		interval = time.Duration(hours) * time.Hour
+	}
+
+	return time.Since(time.Unix(state.LastFullSyncAt, 0)) >= interval
+}
+
+// makes the next sync a full sync, used when a change affects entries that were not updated on Anilist
+func requestFullSync() {
+	appConfig := config.GetAppConfig()
+	state := appConfig.GetSyncState()
+	state.LastFullSyncAt = 0
+	appConfig.SaveSyncState(state)
+}
+
+func getNewestUpdate(data *models.SourceData) int64 {
+	var newest int64
+
+	for _, media := range data.MediaMap {
+		if media.UpdatedAt > newest {
+			newest = media.UpdatedAt
+		}
+	}
+	for _, entry := range data.Unmapped {
+		if entry.Media.UpdatedAt > newest {
+			newest = entry.Media.UpdatedAt
+		}
+	}
+
+	return newest
+}
+
+// calls the configured webhooks. They are still called after an interrupt, the HTTP client timeout bounds the wait
+func notifySync(ctx context.Context, result *models.SyncResult, err error) {
+	settings := config.GetAppConfig().GetSettings()
+	if len(settings.Notify) == 0 {
+		return
+	}
+
+	client, clientErr := utils.NewHTTPClient("notify", settings.HTTP, nil)
+	if clientErr != nil {
+		slog.Warn("Failed to send notifications", "error", clientErr)
+		return
+	}
+
+	notify.Send(context.WithoutCancel(ctx), client, settings.Notify, notify.NewEvent(config.GetProfile(), result, err))
+}
+
+// writes the report and exits with a non-zero code when some changes were not applied.
+// The lock is released first since exiting skips deferred calls
+func finishSync(result *models.SyncResult, reportFormat string, lock *config.Lock) {
+	if err := report.Write(os.Stdout, reportFormat, result); err != nil {
+		utils.Fatal("Failed to write the sync report", "error", err)
+	}
+
+	if result.IsPartial() {
+		lock.Release()
+		os.Exit(1)
+	}
+}
+
+func resumeSync(ctx context.Context, s *session, reportFormat string, lock *config.Lock) {
+	journal := config.GetAppConfig().GetSyncJournal()
+	if journal == nil || journal.IsComplete() {
+		fmt.Println("No unfinished sync to resume.")
+		return
+	}
+
+	// the current MAL list decides which changes still need to be applied
+	malData, err := s.mal.GetUserData(cache.Refresh(ctx), s.malCode)
+	if err != nil {
+		notifySync(ctx, nil, err)
+		utils.Fatal("Failed to fetch the MAL library", "error", err)
+	}
+
+	result := s.mal.ResumeSync(ctx, s.malCode, journal, malData)
+	notifySync(ctx, result, nil)
+	finishSync(result, reportFormat, lock)
+}
+
+func runUnmapped(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("unmapped", flag.ExitOnError)
+	flags.Parse(args)
+
+	anilistClient := newAnilistClient()
+
+	anilistCode, err := anilistClient.GetAccessCode(ctx)
+	if err != nil {
+		utils.Fatal("Failed to get the Anilist access token", "error", err)
+	}
+
+	anilistData, err := fetchAnilistData(ctx, anilistClient, anilistCode)
+	if err != nil {
+		utils.Fatal("Failed to fetch the Anilist library", "error", err)
+	}
+
+	if len(anilistData.Unmapped) == 0 {
+		fmt.Println("All Anilist entries have a MAL ID.")
+		return
+	}
+
+	printUnmappedReport(anilistData.Unmapped)
+}
+
+// fetches both libraries at the same time, a failure on either side cancels the other.
+// A non-zero since only fetches the Anilist entries updated after it. The MAL library skips the
+// cache unless readOnly is set, a plan applied to it must not be based on an outdated list
+func fetchLibraries(ctx context.Context, s *session, since int64, readOnly bool) (*models.SourceData, *models.SourceData, error) {
+	var anilistData, malData *models.SourceData
+
+	group, ctx := utils.NewGroup(ctx)
+
+	group.Go(func() error {
+		var err error
+		if since == 0 {
+			anilistData, err = fetchAnilistData(ctx, s.anilist, s.anilistCode)
+		} else {
+			anilistData, err = fetchAnilistChanges(ctx, s, since)
+		}
+		if err != nil {
+			return &models.AppError{
+				Message: "Failed to fetch the Anilist library",
+				Err:     err,
+			}
+		}
+		return nil
+	})
+
+	group.Go(func() error {
+		malCtx := ctx
+		if !readOnly {
+			malCtx = cache.Refresh(ctx)
+		}
+
+		var err error
+		malData, err = s.mal.GetUserData(malCtx, s.malCode)
+		if err != nil {
+			return &models.AppError{
+				Message: "Failed to fetch the MAL library",
+				Err:     err,
+			}
+		}
+		return nil
+	})
+
+	if err := group.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	recordHistory("anilist", anilistData, since != 0)
+	recordHistory("mal", malData, false)
+
+	return anilistData, malData, nil
+}
+
+func fetchAnilistChanges(ctx context.Context, s *session, since int64) (*models.SourceData, error) {
+	viewer, err := s.anilist.GetAuthenticatedUser(ctx, s.anilistCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.anilist.GetUserDataSince(ctx, viewer.ID, since, &s.anilistCode)
+}
+
+// history is a convenience, a failure to record it doesn't stop the command
+func recordHistory(service string, data *models.SourceData, partial bool) {
+	if err := config.GetAppConfig().GetHistory().Record(service, data, partial, time.Now()); err != nil {
+		slog.Warn("Failed to record the library history", "service", service, "error", err)
+	}
+}
+
+func fetchAnilistData(ctx context.Context, client *anilist.Client, anilistCode string) (*models.SourceData, error) {
+	viewer, err := client.GetAuthenticatedUser(ctx, anilistCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetUserData(ctx, viewer.ID, &anilistCode)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+	"ipmanlk/ani2mal/xref"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const xrefUsage = `Usage:
+  ani2mal xref import <anime-offline-database.json>
+  ani2mal xref status
+  ani2mal xref lookup <provider> <id> [anime|manga]
+`
+
+func runXref(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, xrefUsage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "import":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, xrefUsage)
+			os.Exit(2)
+		}
+
+		index, err := xref.Import(args[1])
+		if err != nil {
+			utils.Fatal("Failed to import the cross-reference dataset", "path", args[1], "error", err)
+		}
+		requestFullSync()
+
+		fmt.Printf("Imported %d cross-referenced titles from %s\n", len(index.Entries), index.Source)
+
+	case "status":
+		index := config.GetAppConfig().GetXrefIndex()
+		if index == nil {
+			fmt.Println("No cross-reference database imported. Use `ani2mal xref import <file>` to import one.")
+			return
+		}
+
+		fmt.Printf("Source:      %s\n", index.Source)
+		fmt.Printf("Last update: %s\n", index.LastUpdate)
+		fmt.Printf("Imported at: %s\n", time.Unix(index.ImportedAt, 0).Format(time.DateTime))
+		fmt.Printf("Titles:      %d\n", len(index.Entries))
+
+	case "lookup":
+		if len(args) < 3 || len(args) > 4 {
+			fmt.Fprint(os.Stderr, xrefUsage)
+			os.Exit(2)
+		}
+
+		id, err := strconv.Atoi(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid ID: %s\n", args[2])
+			os.Exit(2)
+		}
+
+		mediaType := models.MediaTypeAnime
+		if len(args) == 4 {
+			mediaType = models.MediaType(args[3])
+		}
+
+		db := xref.Load()
+		if db == nil {
+			utils.Fatal("No cross-reference database imported")
+		}
+
+		entry, ok := db.Lookup(mediaType, args[1], id)
+		if !ok {
+			fmt.Printf("No %s entry found for %s ID %d\n", mediaType, args[1], id)
+			os.Exit(1)
+		}
+
+		printXrefEntry(entry)
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown xref command: %s\n\n%s", args[0], xrefUsage)
+		os.Exit(2)
+	}
+}
+
+func printXrefEntry(entry models.XrefEntry) {
+	fmt.Printf("%s (%s, %d)\n", entry.Title, entry.Type, entry.Year)
+
+	providers := make([]string, 0, len(entry.IDs))
+	for provider := range entry.IDs {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+
+	for _, provider := range providers {
+		fmt.Printf("  %-10s %d\n", provider, entry.IDs[provider])
+	}
+}
@@ -2,12 +2,21 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"ipmanlk/ani2mal/cache"
+	"ipmanlk/ani2mal/history"
 	"ipmanlk/ani2mal/models"
-	"log"
+	"ipmanlk/ani2mal/utils"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 type AppConfig struct {
@@ -15,20 +24,54 @@ type AppConfig struct {
 	malConfigPath     string
 	anilistConfigPath string
 	excludesFilePath  string
+	mappingsFilePath  string
+	xrefIndexPath     string
+	settingsFilePath  string
+	journalFilePath   string
+	backupsDir        string
+	lockFilePath      string
+	stateFilePath     string
+	cacheDir          string
+	historyDir        string
+
+	// set by long running commands that must not exit on a broken settings file
+	settingsMu     sync.RWMutex
+	pinnedSettings *models.Settings
 }
 
 var (
 	once     sync.Once
 	instance *AppConfig
+	profile  string
+
+	cacheDisabled bool
 )
 
+// selects the profile whose configuration is used, must be called before GetAppConfig.
+// Each profile keeps its own logins, mappings, journal and backups
+func SetProfile(name string) error {
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return &models.AppError{
+			Message: fmt.Sprintf("Invalid profile name %q", name),
+		}
+	}
+
+	profile = name
+	return nil
+}
+
+// returns the selected profile, empty for the default configuration
+func GetProfile() string {
+	return profile
+}
+
 func GetAppConfig() *AppConfig {
 	once.Do(
 		func() {
 			configDir, err := getConfigDir()
 
 			if err != nil {
-				log.Fatal("Failed to locate the configuration directory.", err)
+				utils.Fatal("Failed to locate the configuration directory", "error", err)
 			}
 
 			instance = &AppConfig{
@@ -36,6 +79,15 @@ func GetAppConfig() *AppConfig {
 				malConfigPath:     filepath.Join(configDir, "mal.json"),
 				anilistConfigPath: filepath.Join(configDir, "anilist.json"),
 				excludesFilePath:  filepath.Join(configDir, "excludes.json"),
+				mappingsFilePath:  filepath.Join(configDir, "mappings.json"),
+				xrefIndexPath:     filepath.Join(configDir, "xref.json"),
+				settingsFilePath:  filepath.Join(configDir, "settings.json"),
+				journalFilePath:   filepath.Join(configDir, "journal.json"),
+				backupsDir:        filepath.Join(configDir, "backups"),
+				lockFilePath:      filepath.Join(configDir, "sync.lock"),
+				stateFilePath:     filepath.Join(configDir, "state.json"),
+				cacheDir:          filepath.Join(configDir, "cache"),
+				historyDir:        filepath.Join(configDir, "history"),
 			}
 		})
 
@@ -46,13 +98,13 @@ func (cfg *AppConfig) SaveMalConfig(malConfig *models.MalConfig) {
 	jsonData, err := json.MarshalIndent(malConfig, "", " ")
 
 	if err != nil {
-		log.Fatal("Failed to marshal mal config", err)
+		utils.Fatal("Failed to marshal mal config", "error", err)
 	}
 
 	err = os.WriteFile(cfg.malConfigPath, jsonData, 0644)
 
 	if err != nil {
-		log.Fatal("Error writing MAL config", err)
+		utils.Fatal("Error writing MAL config", "error", err)
 	}
 }
 
@@ -60,9 +112,9 @@ func (cfg *AppConfig) GetMalConfig() *models.MalConfig {
 	_, err := os.Stat(cfg.malConfigPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			log.Fatal("Please login to MyAnimeList first")
+			utils.Fatal("Please login to MyAnimeList first")
 		}
-		log.Fatalf("Failed to read MyAnimeList configuration file. Check if file permissions are correct %+v", err)
+		utils.Fatal("Failed to read MyAnimeList configuration file. Check if file permissions are correct", "error", err)
 	}
 
 	content, _ := os.ReadFile(cfg.malConfigPath)
@@ -77,13 +129,13 @@ func (cfg *AppConfig) SaveAnilistConfig(anilistConfig *models.AnilistConfig) {
 	jsonData, err := json.MarshalIndent(anilistConfig, "", " ")
 
 	if err != nil {
-		log.Fatal("Failed to marshal Anilist config", err)
+		utils.Fatal("Failed to marshal Anilist config", "error", err)
 	}
 
 	err = os.WriteFile(cfg.anilistConfigPath, jsonData, 0644)
 
 	if err != nil {
-		log.Fatal("Error writing Anilist config", err)
+		utils.Fatal("Error writing Anilist config", "error", err)
 	}
 }
 
@@ -91,9 +143,9 @@ func (cfg *AppConfig) GetAnilistConfig() *models.AnilistConfig {
 	_, err := os.Stat(cfg.anilistConfigPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			log.Fatal("Please configure Anilist first")
+			utils.Fatal("Please configure Anilist first")
 		}
-		log.Fatalf("Failed to read Anilist configuration file. Check if file permissions are correct %+v", err)
+		utils.Fatal("Failed to read Anilist configuration file. Check if file permissions are correct", "error", err)
 	}
 
 	content, _ := os.ReadFile(cfg.anilistConfigPath)
@@ -104,6 +156,409 @@ func (cfg *AppConfig) GetAnilistConfig() *models.AnilistConfig {
 	return &anilistConfig
 }
 
+func (cfg *AppConfig) SaveIdMappings(mappings map[int]models.IdMapping) {
+	mappingList := make([]models.IdMapping, 0, len(mappings))
+	for _, mapping := range mappings {
+		mappingList = append(mappingList, mapping)
+	}
+
+	sort.Slice(mappingList, func(i, j int) bool {
+		return mappingList[i].AnilistID < mappingList[j].AnilistID
+	})
+
+	jsonData, err := json.MarshalIndent(mappingList, "", " ")
+	if err != nil {
+		utils.Fatal("Failed to marshal ID mappings", "error", err)
+	}
+
+	err = os.WriteFile(cfg.mappingsFilePath, jsonData, 0644)
+	if err != nil {
+		utils.Fatal("Error writing ID mappings", "error", err)
+	}
+}
+
+// returns the ID mappings keyed by Anilist ID, mappings are optional so a missing file is not an error
+func (cfg *AppConfig) GetIdMappings() map[int]models.IdMapping {
+	mappings := make(map[int]models.IdMapping)
+
+	content, err := os.ReadFile(cfg.mappingsFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return mappings
+		}
+		utils.Fatal("Failed to read ID mappings file. Check if file permissions are correct", "error", err)
+	}
+
+	var mappingList []models.IdMapping
+	if err := json.Unmarshal(content, &mappingList); err != nil {
+		utils.Fatal("Failed to parse ID mappings file", "path", cfg.mappingsFilePath, "error", err)
+	}
+
+	for _, mapping := range mappingList {
+		mappings[mapping.AnilistID] = mapping
+	}
+
+	return mappings
+}
+
+func (cfg *AppConfig) SaveExclusions(exclusions map[models.MediaKey]models.Exclusion) {
+	exclusionList := make([]models.Exclusion, 0, len(exclusions))
+	for _, exclusion := range exclusions {
+		exclusionList = append(exclusionList, exclusion)
+	}
+
+	sort.Slice(exclusionList, func(i, j int) bool {
+		return exclusionList[i].Key().String() < exclusionList[j].Key().String()
+	})
+
+	jsonData, err := json.MarshalIndent(exclusionList, "", " ")
+	if err != nil {
+		utils.Fatal("Failed to marshal exclusions", "error", err)
+	}
+
+	err = os.WriteFile(cfg.excludesFilePath, jsonData, 0644)
+	if err != nil {
+		utils.Fatal("Error writing exclusions", "error", err)
+	}
+}
+
+// returns the excluded entries keyed by media key, a missing file means nothing is excluded
+func (cfg *AppConfig) GetExclusions() map[models.MediaKey]models.Exclusion {
+	exclusions := make(map[models.MediaKey]models.Exclusion)
+
+	content, err := os.ReadFile(cfg.excludesFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return exclusions
+		}
+		utils.Fatal("Failed to read exclusions file. Check if file permissions are correct", "error", err)
+	}
+
+	var exclusionList []models.Exclusion
+	if err := json.Unmarshal(content, &exclusionList); err != nil {
+		utils.Fatal("Failed to parse exclusions file", "path", cfg.excludesFilePath, "error", err)
+	}
+
+	for _, exclusion := range exclusionList {
+		exclusions[exclusion.Key()] = exclusion
+	}
+
+	return exclusions
+}
+
+func (cfg *AppConfig) SaveXrefIndex(index *models.XrefIndex) {
+	jsonData, err := json.Marshal(index)
+	if err != nil {
+		utils.Fatal("Failed to marshal cross-reference index", "error", err)
+	}
+
+	err = os.WriteFile(cfg.xrefIndexPath, jsonData, 0644)
+	if err != nil {
+		utils.Fatal("Error writing cross-reference index", "error", err)
+	}
+}
+
+// returns the imported cross-reference index or nil when nothing has been imported
+func (cfg *AppConfig) GetXrefIndex() *models.XrefIndex {
+	content, err := os.ReadFile(cfg.xrefIndexPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		utils.Fatal("Failed to read cross-reference index. Check if file permissions are correct", "error", err)
+	}
+
+	var index models.XrefIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		utils.Fatal("Failed to parse cross-reference index, import the dataset again", "path", cfg.xrefIndexPath, "error", err)
+	}
+
+	return &index
+}
+
+// returns the user settings, settings are optional so a missing file means all defaults
+func (cfg *AppConfig) GetSettings() *models.Settings {
+	cfg.settingsMu.RLock()
+	pinned := cfg.pinnedSettings
+	cfg.settingsMu.RUnlock()
+	if pinned != nil {
+		return pinned
+	}
+
+	settings, err := cfg.LoadSettings()
+	if err != nil {
+		utils.Fatal("Failed to load the settings", "error", err)
+	}
+
+	return settings
+}
+
+// reads and validates the settings file, for callers that can recover from a broken file
+func (cfg *AppConfig) LoadSettings() (*models.Settings, error) {
+	var settings models.Settings
+
+	content, err := os.ReadFile(cfg.settingsFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &settings, nil
+		}
+		return nil, &models.AppError{
+			Message: "Failed to read settings file. Check if file permissions are correct",
+			Err:     err,
+		}
+	}
+
+	if err := json.Unmarshal(content, &settings); err != nil {
+		return nil, &models.AppError{
+			Message: fmt.Sprintf("Failed to parse settings file %s", cfg.settingsFilePath),
+			Err:     err,
+		}
+	}
+
+	if err := settings.Sync.Policies.Validate(); err != nil {
+		return nil, &models.AppError{
+			Message: fmt.Sprintf("Invalid sync policies in settings file %s", cfg.settingsFilePath),
+			Err:     err,
+		}
+	}
+
+	return &settings, nil
+}
+
+// makes GetSettings return settings instead of reading the file, until it is called again
+func (cfg *AppConfig) PinSettings(settings *models.Settings) {
+	cfg.settingsMu.Lock()
+	defer cfg.settingsMu.Unlock()
+	cfg.pinnedSettings = settings
+}
+
+// the journal is replaced atomically so a crash mid write never leaves a corrupt journal behind
+func (cfg *AppConfig) SaveSyncJournal(journal *models.SyncJournal) {
+	jsonData, err := json.MarshalIndent(journal, "", " ")
+	if err != nil {
+		utils.Fatal("Failed to marshal sync journal", "error", err)
+	}
+
+	err = writeFileAtomic(cfg.journalFilePath, jsonData)
+	if err != nil {
+		utils.Fatal("Error writing sync journal", "error", err)
+	}
+}
+
+// returns the journal of the last sync or nil when no sync has been run
+func (cfg *AppConfig) GetSyncJournal() *models.SyncJournal {
+	content, err := os.ReadFile(cfg.journalFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		utils.Fatal("Failed to read sync journal. Check if file permissions are correct", "error", err)
+	}
+
+	var journal models.SyncJournal
+	if err := json.Unmarshal(content, &journal); err != nil {
+		utils.Fatal("Failed to parse sync journal", "path", cfg.journalFilePath, "error", err)
+	}
+
+	return &journal
+}
+
+func (cfg *AppConfig) RemoveSyncJournal() {
+	if err := os.Remove(cfg.journalFilePath); err != nil && !os.IsNotExist(err) {
+		utils.Fatal("Failed to remove sync journal", "path", cfg.journalFilePath, "error", err)
+	}
+}
+
+func (cfg *AppConfig) SaveSyncState(state *models.SyncState) {
+	jsonData, err := json.MarshalIndent(state, "", " ")
+	if err != nil {
+		utils.Fatal("Failed to marshal sync state", "error", err)
+	}
+
+	if err := writeFileAtomic(cfg.stateFilePath, jsonData); err != nil {
+		utils.Fatal("Error writing sync state", "error", err)
+	}
+}
+
+// returns the incremental sync state, an empty state when no sync has finished yet
+func (cfg *AppConfig) GetSyncState() *models.SyncState {
+	var state models.SyncState
+
+	content, err := os.ReadFile(cfg.stateFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &state
+		}
+		utils.Fatal("Failed to read sync state file. Check if file permissions are correct", "error", err)
+	}
+
+	if err := json.Unmarshal(content, &state); err != nil {
+		slog.Warn("Ignoring unreadable sync state, the next sync is a full sync", "path", cfg.stateFilePath, "error", err)
+		return &models.SyncState{}
+	}
+
+	return &state
+}
+
+// Time responses are cached for when no TTL is configured
+const defaultCacheTTL = 10 * time.Minute
+
+// turns the response cache off for this process
+func DisableCache() {
+	cacheDisabled = true
+}
+
+// returns the response cache, nil when it is turned off
+func (cfg *AppConfig) GetCache() *cache.Store {
+	settings := cfg.GetSettings().Cache
+	if cacheDisabled || settings.Disabled {
+		return nil
+	}
+
+	ttl := defaultCacheTTL
+	if settings.TTLMinutes > 0 {
+		ttl = time.Duration(settings.TTLMinutes) * time.Minute
+	}
+
+	return cache.NewStore(cfg.cacheDir, ttl)
+}
+
+func (cfg *AppConfig) ClearCache() error {
+	return cache.Clear(cfg.cacheDir)
+}
+
+func (cfg *AppConfig) GetHistory() *history.Store {
+	return history.NewStore(cfg.historyDir)
+}
+
+// Backups kept when no retention is configured
+const defaultBackupRetention = 10
+
+// saves a backup and deletes the oldest backups beyond the configured retention
+func (cfg *AppConfig) SaveBackup(backup *models.Backup) {
+	jsonData, err := json.Marshal(backup)
+	if err != nil {
+		utils.Fatal("Failed to marshal backup", "error", err)
+	}
+
+	if err := os.MkdirAll(cfg.backupsDir, 0755); err != nil {
+		utils.Fatal("Failed to create the backups directory", "error", err)
+	}
+
+	// a backup is never replaced, it may be the only copy of the list before a sync
+	err = writeFileExclusive(filepath.Join(cfg.backupsDir, backup.Name+".json"), jsonData)
+	if errors.Is(err, fs.ErrExist) {
+		utils.Fatal("Refusing to overwrite an existing backup", "backup", backup.Name)
+	}
+	if err != nil {
+		utils.Fatal("Error writing backup", "error", err)
+	}
+
+	retention := cfg.GetSettings().Backup.Retention
+	if retention <= 0 {
+		retention = defaultBackupRetention
+	}
+
+	backupNames := cfg.ListBackups()
+	for i := retention; i < len(backupNames); i++ {
+		if err := os.Remove(filepath.Join(cfg.backupsDir, backupNames[i]+".json")); err != nil {
+			slog.Warn("Failed to delete old backup", "backup", backupNames[i], "error", err)
+		}
+	}
+}
+
+// returns the names of all backups, newest first
+func (cfg *AppConfig) ListBackups() []string {
+	files, err := os.ReadDir(cfg.backupsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}
+		}
+		utils.Fatal("Failed to read the backups directory", "error", err)
+	}
+
+	backupNames := make([]string, 0, len(files))
+	for _, file := range files {
+		if name, ok := strings.CutSuffix(file.Name(), ".json"); ok && !file.IsDir() {
+			backupNames = append(backupNames, name)
+		}
+	}
+
+	// names start with a sortable timestamp
+	sort.Sort(sort.Reverse(sort.StringSlice(backupNames)))
+
+	return backupNames
+}
+
+func (cfg *AppConfig) GetBackup(name string) (*models.Backup, error) {
+	content, err := os.ReadFile(filepath.Join(cfg.backupsDir, filepath.Base(name)+".json"))
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to read backup " + name,
+			Err:     err,
+		}
+	}
+
+	var backup models.Backup
+	if err := json.Unmarshal(content, &backup); err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to parse backup " + name,
+			Err:     err,
+		}
+	}
+
+	return &backup, nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath, err := writeTempFile(path, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	return os.Rename(tmpPath, path)
+}
+
+// same as writeFileAtomic but fails with fs.ErrExist instead of replacing an existing file
+func writeFileExclusive(path string, data []byte) error {
+	tmpPath, err := writeTempFile(path, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	// unlike a rename, a link fails when the target exists
+	return os.Link(tmpPath, path)
+}
+
+// writes data to a synced temporary file next to path
+func writeTempFile(path string, data []byte) (string, error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	return tmpFile.Name(), nil
+}
+
 func getConfigDir() (string, error) {
 	var configDir string
 	switch currentOs := runtime.GOOS; currentOs {
@@ -123,6 +578,10 @@ func getConfigDir() (string, error) {
 		configDir = filepath.Join(exePath, "ani2mal")
 	}
 
+	if profile != "" {
+		configDir = filepath.Join(configDir, "profiles", profile)
+	}
+
 	if err := os.MkdirAll(configDir, 0755); err != nil {
 		return "", err
 	}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileExclusive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup.json")
+
+	if err := writeFileExclusive(path, []byte("first")); err != nil {
+		t.Fatalf("writeFileExclusive() error = %v", err)
+	}
+
+	err := writeFileExclusive(path, []byte("second"))
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("second writeFileExclusive() error = %v, want fs.ErrExist", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "first" {
+		t.Errorf("file content = %q, %v, want %q", content, err, "first")
+	}
+
+	// the temporary files are cleaned up either way
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory has %d entries, %v, want 1", len(entries), err)
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"ipmanlk/ani2mal/models"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var ErrLocked = errors.New("another sync is running for this profile")
+
+// returned by lockFile when another open file holds the lock
+var errLockBusy = errors.New("lock is held")
+
+// Lock file that keeps two syncs of the same profile from changing MAL at the same time.
+// The operating system releases the lock when the process exits, so a crashed sync never leaves
+// a stale lock behind. The file itself is never removed, another process may already have it open
+type Lock struct {
+	file *os.File
+}
+
+// takes the profile lock, the PID of the holder is written to the file for the error message of the next sync
+func (cfg *AppConfig) AcquireLock() (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.lockFilePath), 0755); err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to create the lock file",
+			Err:     err,
+		}
+	}
+
+	file, err := os.OpenFile(cfg.lockFilePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to create the lock file",
+			Err:     err,
+		}
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+
+		if errors.Is(err, errLockBusy) {
+			message := fmt.Sprintf("Lock %s is held by another process", cfg.lockFilePath)
+			if pid, err := readLockOwner(cfg.lockFilePath); err == nil {
+				message = fmt.Sprintf("Lock %s is held by process %d", cfg.lockFilePath, pid)
+			}
+			return nil, &models.AppError{
+				Message: message,
+				Err:     ErrLocked,
+			}
+		}
+
+		return nil, &models.AppError{
+			Message: "Failed to lock the lock file",
+			Err:     err,
+		}
+	}
+
+	// the owner is only informational, the lock holds even when it can't be written
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+
+	return &Lock{file: file}, nil
+}
+
+// releases the lock, calling it again does nothing
+func (l *Lock) Release() {
+	if l.file == nil {
+		return
+	}
+
+	l.file.Truncate(0)
+	if err := unlockFile(l.file); err != nil {
+		slog.Warn("Failed to unlock the lock file", "path", l.file.Name(), "error", err)
+	}
+	l.file.Close()
+	l.file = nil
+}
+
+func readLockOwner(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err == nil && pid <= 0 {
+		err = fmt.Errorf("invalid process id %d", pid)
+	}
+	return pid, err
+}
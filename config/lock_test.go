@@ -0,0 +1,101 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newLockConfig(t *testing.T) *AppConfig {
+	return &AppConfig{lockFilePath: filepath.Join(t.TempDir(), "sync.lock")}
+}
+
+func TestAcquireLockContention(t *testing.T) {
+	cfg := newLockConfig(t)
+
+	first, err := cfg.AcquireLock()
+	if err != nil {
+		t.Fatalf("AcquireLock() error = %v", err)
+	}
+
+	_, err = cfg.AcquireLock()
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("second AcquireLock() error = %v, want ErrLocked", err)
+	}
+	if !strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) {
+		t.Errorf("second AcquireLock() error = %v, want the holder's PID", err)
+	}
+
+	first.Release()
+	first.Release()
+
+	second, err := cfg.AcquireLock()
+	if err != nil {
+		t.Fatalf("AcquireLock() after Release() error = %v", err)
+	}
+	second.Release()
+}
+
+func TestAcquireLockConcurrent(t *testing.T) {
+	cfg := newLockConfig(t)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		holders int
+		locks   []*Lock
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			lock, err := cfg.AcquireLock()
+			if err != nil {
+				if !errors.Is(err, ErrLocked) {
+					t.Errorf("AcquireLock() error = %v", err)
+				}
+				return
+			}
+
+			mu.Lock()
+			holders++
+			locks = append(locks, lock)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if holders != 1 {
+		t.Errorf("%d goroutines hold the lock, want 1", holders)
+	}
+	for _, lock := range locks {
+		lock.Release()
+	}
+}
+
+// a crashed sync leaves the file behind but the OS lock went away with the process
+func TestAcquireLockLeftoverFile(t *testing.T) {
+	for _, content := range []string{"", "garbage", "999999999"} {
+		cfg := newLockConfig(t)
+		if err := os.WriteFile(cfg.lockFilePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		lock, err := cfg.AcquireLock()
+		if err != nil {
+			t.Fatalf("AcquireLock() with %q in the lock file error = %v", content, err)
+		}
+
+		pid, err := readLockOwner(cfg.lockFilePath)
+		if err != nil || pid != os.Getpid() {
+			t.Errorf("lock owner = %d, %v, want %d", pid, err, os.Getpid())
+		}
+		lock.Release()
+	}
+}
@@ -0,0 +1,347 @@
+package dashboard
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"html/template"
+	"ipmanlk/ani2mal/models"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed templates/index.html
+var templates embed.FS
+
+var indexTemplate = template.Must(template.New("index.html").Funcs(template.FuncMap{
+	"formatTime": func(t time.Time) string { return t.Format(time.DateTime) },
+}).ParseFS(templates, "templates/index.html"))
+
+// fetches both libraries, refresh is set when the user asked for fresh data
+type LoadFunc func(ctx context.Context, refresh bool) (anilistData, malData *models.SourceData, err error)
+
+// returns the changes that make MAL match Anilist
+type PlanFunc func(anilistData, malData *models.SourceData) []models.SyncOp
+
+// applies the approved operations to MAL, anilistData is the library the plan was made from
+type ApplyFunc func(ctx context.Context, plan []models.SyncOp, anilistData *models.SourceData) (*models.SyncResult, error)
+
+// Local web UI to review the pending sync and apply parts of it
+type Server struct {
+	ctx   context.Context
+	load  LoadFunc
+	plan  PlanFunc
+	apply ApplyFunc
+	// host names the UI answers to, anything else could be a DNS rebinding page
+	allowedHosts map[string]bool
+	// sent with every form so other sites can't post to the local server
+	csrfToken string
+
+	mu         sync.Mutex
+	snapshot   *snapshot
+	lastResult *models.SyncResult
+	lastError  string
+}
+
+// Libraries and the plan computed from them
+type snapshot struct {
+	version     string
+	loadedAt    time.Time
+	anilistData *models.SourceData
+	malData     *models.SourceData
+	plan        []models.SyncOp
+}
+
+// ctx outlives individual requests, so a closed browser tab doesn't stop a sync halfway.
+// addr is the address the UI is served on, requests for other hosts are rejected
+func New(ctx context.Context, addr string, load LoadFunc, plan PlanFunc, apply ApplyFunc) (*Server, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to generate the form token",
+			Err:     err,
+		}
+	}
+
+	allowedHosts := map[string]bool{"localhost": true, "127.0.0.1": true, "::1": true}
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		allowedHosts[host] = true
+	}
+
+	return &Server{ctx: ctx, load: load, plan: plan, apply: apply, allowedHosts: allowedHosts, csrfToken: hex.EncodeToString(token)}, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/reload", s.handleReload)
+	mux.HandleFunc("/apply", s.handleApply)
+	return s.checkHost(mux)
+}
+
+// a page on another site can point its own domain at this server and read the form token,
+// so only requests for the listen address or localhost are served
+func (s *Server) checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAllowedHost(r.Host) {
+			slog.Warn("Rejected web UI request for an unknown host", "host", r.Host)
+			http.Error(w, "Unknown host", http.StatusForbidden)
+			return
+		}
+
+		// browsers always send Origin with cross-site form posts
+		if origin := r.Header.Get("Origin"); r.Method == http.MethodPost && origin != "" {
+			originURL, err := url.Parse(origin)
+			if err != nil || originURL.Host != r.Host {
+				slog.Warn("Rejected web UI request from another origin", "origin", origin)
+				http.Error(w, "Cross origin requests are not allowed", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) isAllowedHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		// no port in the Host header
+		host = strings.Trim(hostport, "[]")
+	}
+	return s.allowedHosts[host]
+}
+
+// loads the libraries, keeping the error for the page instead of returning it
+func (s *Server) Reload(refresh bool) {
+	anilistData, malData, err := s.load(s.ctx, refresh)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		slog.Error("Failed to load the libraries", "error", err)
+		s.lastError = err.Error()
+		return
+	}
+
+	loadedAt := time.Now()
+	s.snapshot = &snapshot{
+		version:     strconv.FormatInt(loadedAt.UnixNano(), 36),
+		loadedAt:    loadedAt,
+		anilistData: anilistData,
+		malData:     malData,
+		plan:        s.plan(anilistData, malData),
+	}
+	s.lastError = ""
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	data := s.getPageData()
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, data); err != nil {
+		slog.Error("Failed to render the dashboard", "error", err)
+	}
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if !s.checkForm(w, r) {
+		return
+	}
+
+	s.Reload(true)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *Server) handleApply(w http.ResponseWriter, r *http.Request) {
+	if !s.checkForm(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	current := s.snapshot
+	s.mu.Unlock()
+
+	// the indexes only mean something for the plan the page was rendered from
+	if current == nil || r.PostForm.Get("version") != current.version {
+		s.setError("The plan changed since the page was loaded, review it again before applying")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	approved := make([]models.SyncOp, 0)
+	for _, value := range r.PostForm["op"] {
+		index, err := strconv.Atoi(value)
+		if err != nil || index < 0 || index >= len(current.plan) {
+			http.Error(w, "Invalid operation", http.StatusBadRequest)
+			return
+		}
+		approved = append(approved, current.plan[index])
+	}
+
+	if len(approved) == 0 {
+		s.setError("No operations were approved")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	slog.Info("Applying approved operations", "approved", len(approved), "rejected", len(current.plan)-len(approved))
+
+	result, err := s.apply(s.ctx, approved, current.anilistData)
+	if err != nil {
+		s.setError(err.Error())
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	s.mu.Lock()
+	s.lastResult = result
+	s.mu.Unlock()
+
+	// rejected operations stay in the plan built from the updated lists
+	s.Reload(true)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// only accepts POST requests carrying the form token
+func (s *Server) checkForm(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.PostForm.Get("token")), []byte(s.csrfToken)) != 1 {
+		http.Error(w, "Invalid form token, reload the page", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) setError(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = message
+}
+
+type pageData struct {
+	Token    string
+	Version  string
+	LoadedAt time.Time
+	Loaded   bool
+	Error    string
+	Result   *models.SyncResult
+	Counts   map[string]int
+	Ops      []opView
+	Unmapped []models.UnmappedMedia
+	Rows     []libraryRow
+}
+
+type opView struct {
+	Index   int
+	Kind    models.SyncOpKind
+	Media   models.Media
+	Changes []models.FieldChange
+}
+
+// Entry of either library, Anilist or Mal is nil when the entry is missing there
+type libraryRow struct {
+	Key     models.MediaKey
+	Title   string
+	Anilist *models.Media
+	Mal     *models.Media
+	Differs bool
+}
+
+// expects s.mu to be held
+func (s *Server) getPageData() pageData {
+	data := pageData{
+		Token:  s.csrfToken,
+		Error:  s.lastError,
+		Result: s.lastResult,
+		Counts: make(map[string]int),
+	}
+
+	if s.snapshot == nil {
+		return data
+	}
+
+	data.Loaded = true
+	data.Version = s.snapshot.version
+	data.LoadedAt = s.snapshot.loadedAt
+	data.Unmapped = s.snapshot.anilistData.Unmapped
+
+	for i, op := range s.snapshot.plan {
+		view := opView{Index: i, Kind: op.Kind, Media: op.Media}
+		if op.Kind != models.SyncOpDelete {
+			view.Changes = models.GetFieldChanges(op.Previous, op.Media)
+		}
+		data.Ops = append(data.Ops, view)
+		data.Counts[string(op.Kind)]++
+	}
+
+	data.Rows = getLibraryRows(s.snapshot.anilistData, s.snapshot.malData)
+
+	return data
+}
+
+// pairs the entries of both libraries, sorted by type and title
+func getLibraryRows(anilistData, malData *models.SourceData) []libraryRow {
+	rows := make(map[models.MediaKey]*libraryRow)
+
+	for key, media := range anilistData.MediaMap {
+		media := media
+		rows[key] = &libraryRow{Key: key, Title: media.Title, Anilist: &media}
+	}
+
+	for key, media := range malData.MediaMap {
+		media := media
+		row, ok := rows[key]
+		if !ok {
+			row = &libraryRow{Key: key, Title: media.Title}
+			rows[key] = row
+		}
+		row.Mal = &media
+	}
+
+	sorted := make([]libraryRow, 0, len(rows))
+	for _, row := range rows {
+		row.Differs = row.Anilist == nil || row.Mal == nil || len(models.GetFieldChanges(row.Mal, *row.Anilist)) > 0
+		sorted = append(sorted, *row)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Key.Type != sorted[j].Key.Type {
+			return sorted[i].Key.Type < sorted[j].Key.Type
+		}
+		return sorted[i].Title < sorted[j].Title
+	})
+
+	return sorted
+}
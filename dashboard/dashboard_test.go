@@ -0,0 +1,89 @@
+package dashboard
+
+import (
+	"context"
+	"ipmanlk/ani2mal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T, addr string) *Server {
+	load := func(ctx context.Context, refresh bool) (*models.SourceData, *models.SourceData, error) {
+		return models.NewSourceData(), models.NewSourceData(), nil
+	}
+	plan := func(anilistData, malData *models.SourceData) []models.SyncOp {
+		return nil
+	}
+	apply := func(ctx context.Context, plan []models.SyncOp, anilistData *models.SourceData) (*models.SyncResult, error) {
+		t.Error("apply was called")
+		return nil, nil
+	}
+
+	server, err := New(context.Background(), addr, load, plan, apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestHandlerHost(t *testing.T) {
+	tests := []struct {
+		addr string
+		host string
+		want int
+	}{
+		{"127.0.0.1:8080", "127.0.0.1:8080", http.StatusOK},
+		{"127.0.0.1:8080", "localhost:8080", http.StatusOK},
+		{"127.0.0.1:8080", "[::1]:8080", http.StatusOK},
+		{"127.0.0.1:8080", "localhost", http.StatusOK},
+		{"192.168.1.5:8080", "192.168.1.5:8080", http.StatusOK},
+		{"127.0.0.1:8080", "attacker.example:8080", http.StatusForbidden},
+		{":8080", "attacker.example:8080", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		handler := newTestServer(t, tt.addr).Handler()
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("GET / on %s with Host %s = %d, want %d", tt.addr, tt.host, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestHandlerOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSeeOther},
+		{"http://127.0.0.1:8080", http.StatusSeeOther},
+		{"http://attacker.example", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		server := newTestServer(t, "127.0.0.1:8080")
+		handler := server.Handler()
+
+		form := "token=" + server.csrfToken
+		req := httptest.NewRequest(http.MethodPost, "/reload", strings.NewReader(form))
+		req.Host = "127.0.0.1:8080"
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("POST /reload with Origin %q = %d, want %d", tt.origin, rec.Code, tt.want)
+		}
+	}
+}
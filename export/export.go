@@ -0,0 +1,290 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"ipmanlk/ani2mal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCSV      = "csv"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+func IsValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatJSON || format == FormatMarkdown
+}
+
+// Exportable field of an entry, value returns nil for empty values
+type column struct {
+	name  string
+	value func(models.Media) any
+}
+
+var columns = []column{
+	{"type", func(m models.Media) any { return string(m.Type) }},
+	{"id", func(m models.Media) any { return orNil(m.ID) }},
+	{"title", func(m models.Media) any { return m.Title }},
+	{"status", func(m models.Media) any { return string(m.Status) }},
+	{"score", func(m models.Media) any { return m.Score }},
+	{"progress", func(m models.Media) any { return m.Progress }},
+	{"length", func(m models.Media) any { return orNil(m.Length) }},
+	{"repeat", func(m models.Media) any { return m.Repeat }},
+	{"duration", func(m models.Media) any { return orNil(m.Duration) }},
+	{"start_date", func(m models.Media) any { return orEmpty(m.StartDate) }},
+	{"finish_date", func(m models.Media) any { return orEmpty(m.FinishDate) }},
+	{"notes", func(m models.Media) any { return orEmpty(m.Notes) }},
+	{"updated_at", func(m models.Media) any {
+		if m.UpdatedAt == 0 {
+			return nil
+		}
+		return time.Unix(m.UpdatedAt, 0).UTC().Format(time.RFC3339)
+	}},
+}
+
+// Columns written when none are selected
+var DefaultColumns = []string{"type", "id", "title", "status", "score", "progress", "length"}
+
+// Names of every column, in their default order
+func ColumnNames() []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// Selects the entries to export, zero values match everything
+type Filter struct {
+	Type     models.MediaType
+	Statuses []models.MediaStatus
+	MinScore int
+	MaxScore int
+}
+
+func (f Filter) Matches(media models.Media) bool {
+	if f.Type != "" && media.Type != f.Type {
+		return false
+	}
+
+	if len(f.Statuses) > 0 {
+		matched := false
+		for _, status := range f.Statuses {
+			if media.Status == status {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if f.MinScore > 0 && media.Score < f.MinScore {
+		return false
+	}
+	if f.MaxScore > 0 && media.Score > f.MaxScore {
+		return false
+	}
+
+	return true
+}
+
+// returns the entries of a library that match the filter, sorted by type and title.
+// Unmapped Anilist entries are included without a MAL ID
+func Entries(data *models.SourceData, filter Filter) []models.Media {
+	entries := make([]models.Media, 0, len(data.Anime)+len(data.Manga)+len(data.Unmapped))
+
+	candidates := append(append([]models.Media(nil), data.Anime...), data.Manga...)
+	for _, unmapped := range data.Unmapped {
+		candidates = append(candidates, unmapped.Media)
+	}
+
+	for _, media := range candidates {
+		if filter.Matches(media) {
+			entries = append(entries, media)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Type != entries[j].Type {
+			return entries[i].Type < entries[j].Type
+		}
+		return strings.ToLower(entries[i].Title) < strings.ToLower(entries[j].Title)
+	})
+
+	return entries
+}
+
+// writes the selected columns of entries in the given format
+func Write(w io.Writer, format string, entries []models.Media, columnNames []string) error {
+	selected, err := selectColumns(columnNames)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, entries, selected)
+	case FormatJSON:
+		return writeJSON(w, entries, selected)
+	case FormatMarkdown:
+		return writeMarkdown(w, entries, selected)
+	default:
+		return fmt.Errorf("Unknown export format %q, expected %s, %s or %s", format, FormatCSV, FormatJSON, FormatMarkdown)
+	}
+}
+
+// checks that every column exists, so a typo fails before anything is fetched
+func ValidateColumns(names []string) error {
+	_, err := selectColumns(names)
+	return err
+}
+
+func selectColumns(names []string) ([]column, error) {
+	if len(names) == 0 {
+		names = DefaultColumns
+	}
+
+	selected := make([]column, 0, len(names))
+
+	for _, name := range names {
+		found := false
+		for _, c := range columns {
+			if c.name == name {
+				selected = append(selected, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown column %q, expected one of %s", name, strings.Join(ColumnNames(), ", "))
+		}
+	}
+
+	return selected, nil
+}
+
+func writeCSV(w io.Writer, entries []models.Media, selected []column) error {
+	writer := csv.NewWriter(w)
+
+	header := make([]string, len(selected))
+	for i, c := range selected {
+		header[i] = c.name
+	}
+	writer.Write(header)
+
+	for _, media := range entries {
+		record := make([]string, len(selected))
+		for i, c := range selected {
+			record[i] = formatValue(c.value(media))
+		}
+		writer.Write(record)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// objects keep the column order, which encoding a map would lose
+func writeJSON(w io.Writer, entries []models.Media, selected []column) error {
+	var b bytes.Buffer
+	b.WriteByte('[')
+
+	for i, media := range entries {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('{')
+		for j, c := range selected {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			key, _ := json.Marshal(c.name)
+			value, err := json.Marshal(c.value(media))
+			if err != nil {
+				return err
+			}
+			b.Write(key)
+			b.WriteByte(':')
+			b.Write(value)
+		}
+		b.WriteByte('}')
+	}
+
+	b.WriteByte(']')
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, b.Bytes(), "", " "); err != nil {
+		return err
+	}
+	indented.WriteByte('\n')
+
+	_, err := indented.WriteTo(w)
+	return err
+}
+
+// pipes would end the cell early, so they are escaped
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\n", " ")
+
+func writeMarkdown(w io.Writer, entries []models.Media, selected []column) error {
+	var b strings.Builder
+
+	b.WriteString("|")
+	for _, c := range selected {
+		b.WriteString(" " + c.name + " |")
+	}
+	b.WriteString("\n|")
+	for range selected {
+		b.WriteString("---|")
+	}
+	b.WriteString("\n")
+
+	for _, media := range entries {
+		b.WriteString("|")
+		for _, c := range selected {
+			b.WriteString(" " + markdownEscaper.Replace(formatValue(c.value(media))) + " |")
+		}
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func orNil(value int) any {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
+func orEmpty(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
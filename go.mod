@@ -1,3 +1,3 @@
 module ipmanlk/ani2mal
 
-go 1.20
+go 1.21
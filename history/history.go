@@ -0,0 +1,214 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"ipmanlk/ani2mal/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// One line of a history file. The first full line of a file holds every entry,
+// later lines only the entries that changed since the line before
+type record struct {
+	At int64 `json:"at"`
+	// set when the record was made from a whole library, only those can notice removals
+	Full    bool              `json:"full,omitempty"`
+	Changed []models.Media    `json:"changed,omitempty"`
+	Removed []models.MediaKey `json:"removed,omitempty"`
+}
+
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeUpdated ChangeKind = "updated"
+	ChangeRemoved ChangeKind = "removed"
+)
+
+// Recorded change of one entry. Media is the entry after the change, or before it for removals
+type Change struct {
+	At     time.Time            `json:"at"`
+	Kind   ChangeKind           `json:"kind"`
+	Media  models.Media         `json:"media"`
+	Fields []models.FieldChange `json:"fields,omitempty"`
+}
+
+// Append-only store with one JSON lines file per service
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// appends the entries that changed since the last recorded state. Partial data, such as
+// an incremental fetch, only holds changed entries so missing entries are not treated as removed.
+// Partial data is not recorded until a full library has been, it would be mistaken for the whole library.
+// Entries without a MAL ID have no stable key and are not recorded
+func (s *Store) Record(service string, data *models.SourceData, partial bool, at time.Time) error {
+	records, err := s.read(service)
+	if err != nil {
+		return err
+	}
+
+	records = fromBaseline(records)
+	if partial && len(records) == 0 {
+		return nil
+	}
+	state := replay(records)
+
+	rec := record{At: at.Unix(), Full: !partial}
+
+	for key, media := range data.MediaMap {
+		if previous, ok := state[key]; !ok || !isSameState(previous, media) {
+			rec.Changed = append(rec.Changed, media)
+		}
+	}
+
+	if !partial {
+		for key := range state {
+			if _, ok := data.MediaMap[key]; !ok {
+				rec.Removed = append(rec.Removed, key)
+			}
+		}
+	}
+
+	if len(rec.Changed) == 0 && len(rec.Removed) == 0 {
+		return nil
+	}
+
+	// a stable order keeps the file diffable
+	sort.Slice(rec.Changed, func(i, j int) bool { return rec.Changed[i].Key().String() < rec.Changed[j].Key().String() })
+	sort.Slice(rec.Removed, func(i, j int) bool { return rec.Removed[i].String() < rec.Removed[j].String() })
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path(service), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// returns the changes recorded after since, oldest first. The first full record only sets
+// the starting state, so the entries it adds are not reported as changes
+func (s *Store) Changes(service string, since time.Time) ([]Change, error) {
+	records, err := s.read(service)
+	if err != nil {
+		return nil, err
+	}
+	records = fromBaseline(records)
+
+	changes := make([]Change, 0)
+	state := make(map[models.MediaKey]models.Media)
+
+	for i, rec := range records {
+		at := time.Unix(rec.At, 0)
+		report := i > 0 && !at.Before(since)
+
+		for _, media := range rec.Changed {
+			previous, ok := state[media.Key()]
+			state[media.Key()] = media
+
+			if !report {
+				continue
+			}
+			if !ok {
+				changes = append(changes, Change{At: at, Kind: ChangeAdded, Media: media, Fields: models.GetFieldChanges(nil, media)})
+				continue
+			}
+			changes = append(changes, Change{At: at, Kind: ChangeUpdated, Media: media, Fields: models.GetFieldChanges(&previous, media)})
+		}
+
+		for _, key := range rec.Removed {
+			previous, ok := state[key]
+			delete(state, key)
+
+			if report && ok {
+				changes = append(changes, Change{At: at, Kind: ChangeRemoved, Media: previous})
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+func (s *Store) path(service string) string {
+	return filepath.Join(s.dir, service+".jsonl")
+}
+
+func (s *Store) read(service string) ([]record, error) {
+	file, err := os.Open(s.path(service))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	records := make([]record, 0)
+	scanner := bufio.NewScanner(file)
+	// the first record holds a whole library
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, &models.AppError{
+				Message: fmt.Sprintf("Failed to parse line %d of %s", line, s.path(service)),
+				Err:     err,
+			}
+		}
+		records = append(records, rec)
+	}
+
+	return records, scanner.Err()
+}
+
+// drops the records before the first full one, partial records have nothing to build on without it
+func fromBaseline(records []record) []record {
+	for i, rec := range records {
+		if rec.Full {
+			return records[i:]
+		}
+	}
+	return nil
+}
+
+// rebuilds the latest recorded state
+func replay(records []record) map[models.MediaKey]models.Media {
+	state := make(map[models.MediaKey]models.Media)
+
+	for _, rec := range records {
+		for _, media := range rec.Changed {
+			state[media.Key()] = media
+		}
+		for _, key := range rec.Removed {
+			delete(state, key)
+		}
+	}
+
+	return state
+}
+
+// only the list fields are compared, metadata such as titles or update times don't make a change
+func isSameState(a, b models.Media) bool {
+	return a.Status == b.Status && a.Score == b.Score && a.Progress == b.Progress && a.Repeat == b.Repeat &&
+		a.StartDate == b.StartDate && a.FinishDate == b.FinishDate && a.Notes == b.Notes
+}
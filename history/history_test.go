@@ -0,0 +1,217 @@
+package history
+
+import (
+	"ipmanlk/ani2mal/models"
+	"os"
+	"testing"
+	"time"
+)
+
+func newData(entries ...models.Media) *models.SourceData {
+	data := models.NewSourceData()
+	for _, media := range entries {
+		data.MediaMap[media.Key()] = media
+		if media.Type == models.MediaTypeManga {
+			data.Manga = append(data.Manga, media)
+		} else {
+			data.Anime = append(data.Anime, media)
+		}
+	}
+	return data
+}
+
+func anime(id, progress int, status models.MediaStatus) models.Media {
+	return models.Media{ID: id, Type: models.MediaTypeAnime, Title: "Anime", Progress: progress, Status: status}
+}
+
+func TestChanges(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	type step struct {
+		data    *models.SourceData
+		partial bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+		since time.Time
+		want  []ChangeKind
+	}{
+		{
+			name:  "first full record is the baseline",
+			steps: []step{{newData(anime(1, 3, models.MediaStatusCurrent)), false}},
+			want:  []ChangeKind{},
+		},
+		{
+			name: "updates, adds and removals after the baseline",
+			steps: []step{
+				{newData(anime(1, 3, models.MediaStatusCurrent), anime(2, 0, models.MediaStatusPlanning)), false},
+				{newData(anime(1, 5, models.MediaStatusCurrent), anime(3, 1, models.MediaStatusCurrent)), false},
+			},
+			want: []ChangeKind{ChangeUpdated, ChangeAdded, ChangeRemoved},
+		},
+		{
+			name: "partial records don't remove missing entries",
+			steps: []step{
+				{newData(anime(1, 3, models.MediaStatusCurrent), anime(2, 0, models.MediaStatusPlanning)), false},
+				{newData(anime(1, 4, models.MediaStatusCurrent)), true},
+			},
+			want: []ChangeKind{ChangeUpdated},
+		},
+		{
+			name: "partial records before a baseline are skipped",
+			steps: []step{
+				{newData(anime(1, 3, models.MediaStatusCurrent)), true},
+				{newData(anime(1, 3, models.MediaStatusCurrent), anime(2, 0, models.MediaStatusPlanning)), false},
+			},
+			want: []ChangeKind{},
+		},
+		{
+			name: "unchanged libraries record nothing",
+			steps: []step{
+				{newData(anime(1, 3, models.MediaStatusCurrent)), false},
+				{newData(anime(1, 3, models.MediaStatusCurrent)), false},
+			},
+			want: []ChangeKind{},
+		},
+		{
+			name: "changes before since are left out",
+			steps: []step{
+				{newData(anime(1, 3, models.MediaStatusCurrent)), false},
+				{newData(anime(1, 4, models.MediaStatusCurrent)), false},
+				{newData(anime(1, 5, models.MediaStatusCompleted)), false},
+			},
+			since: start.Add(90 * time.Minute),
+			want:  []ChangeKind{ChangeUpdated},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore(t.TempDir())
+
+			for i, step := range tt.steps {
+				if err := store.Record("anilist", step.data, step.partial, start.Add(time.Duration(i)*time.Hour)); err != nil {
+					t.Fatalf("Record() error = %v", err)
+				}
+			}
+
+			changes, err := store.Changes("anilist", tt.since)
+			if err != nil {
+				t.Fatalf("Changes() error = %v", err)
+			}
+
+			if len(changes) != len(tt.want) {
+				t.Fatalf("Changes() returned %d changes, want %d: %+v", len(changes), len(tt.want), changes)
+			}
+			for i, change := range changes {
+				if change.Kind != tt.want[i] {
+					t.Errorf("change %d kind = %s, want %s", i, change.Kind, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChangesFields(t *testing.T) {
+	store := NewStore(t.TempDir())
+	start := time.Unix(1700000000, 0)
+
+	store.Record("mal", newData(anime(1, 3, models.MediaStatusCurrent)), false, start)
+	store.Record("mal", newData(anime(1, 12, models.MediaStatusCompleted)), false, start.Add(time.Hour))
+
+	changes, err := store.Changes("mal", time.Time{})
+	if err != nil {
+		t.Fatalf("Changes() error = %v", err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("Changes() returned %d changes, want 1", len(changes))
+	}
+
+	fields := make(map[string]models.FieldChange)
+	for _, field := range changes[0].Fields {
+		fields[field.Field] = field
+	}
+
+	if got := fields["status"]; got.From != "current" || got.To != "completed" {
+		t.Errorf("status change = %+v, want current -> completed", got)
+	}
+	if got := fields["progress"]; got.From != "3" || got.To != "12" {
+		t.Errorf("progress change = %+v, want 3 -> 12", got)
+	}
+}
+
+func TestRecordPartialBeforeBaseline(t *testing.T) {
+	store := NewStore(t.TempDir())
+	start := time.Unix(1700000000, 0)
+
+	if err := store.Record("anilist", newData(anime(1, 3, models.MediaStatusCurrent)), true, start); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if _, err := os.Stat(store.path("anilist")); !os.IsNotExist(err) {
+		t.Fatalf("partial data before a baseline was written, stat error = %v", err)
+	}
+
+	full := newData(anime(1, 3, models.MediaStatusCurrent), anime(2, 0, models.MediaStatusPlanning))
+	if err := store.Record("anilist", full, false, start.Add(time.Hour)); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	records, err := store.read("anilist")
+	if err != nil {
+		t.Fatalf("read() error = %v", err)
+	}
+	if len(records) != 1 || !records[0].Full || len(records[0].Changed) != 2 {
+		t.Errorf("records = %+v, want one full record with both entries", records)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	first := anime(1, 3, models.MediaStatusCurrent)
+	second := anime(2, 0, models.MediaStatusPlanning)
+	updated := anime(1, 5, models.MediaStatusCurrent)
+
+	tests := []struct {
+		name    string
+		records []record
+		want    map[models.MediaKey]models.Media
+	}{
+		{
+			name:    "no records",
+			records: nil,
+			want:    map[models.MediaKey]models.Media{},
+		},
+		{
+			name: "later records replace entries",
+			records: []record{
+				{Full: true, Changed: []models.Media{first, second}},
+				{Changed: []models.Media{updated}},
+			},
+			want: map[models.MediaKey]models.Media{first.Key(): updated, second.Key(): second},
+		},
+		{
+			name: "removed entries are dropped",
+			records: []record{
+				{Full: true, Changed: []models.Media{first, second}},
+				{Full: true, Removed: []models.MediaKey{second.Key()}},
+			},
+			want: map[models.MediaKey]models.Media{first.Key(): first},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := replay(tt.records)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("replay() has %d entries, want %d", len(got), len(tt.want))
+			}
+			for key, media := range tt.want {
+				if got[key] != media {
+					t.Errorf("replay()[%s] = %+v, want %+v", key, got[key], media)
+				}
+			}
+		})
+	}
+}
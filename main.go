@@ -1,30 +1,149 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"ipmanlk/ani2mal/anilist"
-	"ipmanlk/ani2mal/mal"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/utils"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
+const usage = `Usage: ani2mal [global options] <command> [options]
+
+Global options:
+  -profile name         Use a separate configuration, login and lock for this profile
+  -verbose              Log debug messages, including every API request
+  -quiet                Only log warnings and errors
+  -log-format text|json Format of the log lines written to stderr (default text)
+  -no-cache             Fetch lists and search results from the APIs instead of the response cache
+
+Commands:
+  login      Log in to anilist or mal
+  sync       Sync the Anilist library to MyAnimeList (default)
+  daemon     Keep running and sync on an interval
+  serve      Review and apply the pending sync in a local web UI
+  unmapped   List Anilist entries that have no MAL ID
+  stats      Compare statistics of the Anilist and MAL libraries
+  export     Write a library as CSV, JSON or Markdown
+  history    Show how the libraries changed over time
+  mapping    Add, list or remove Anilist to MAL ID overrides
+  exclude    Add, list or remove entries that syncs never change on MAL
+  xref       Import and query an offline ID cross-reference database
+  undo       Restore MAL to the backup taken before the last change
+  restore    List backups or restore MAL to one of them
+  cache      Clear the response cache
+`
+
 func main() {
-	fmt.Println("Hello, World!")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	verbose := flag.Bool("verbose", false, "log debug messages")
+	quiet := flag.Bool("quiet", false, "only log warnings and errors")
+	logFormat := flag.String("log-format", utils.LogFormatText, "log format: text or json")
+	profile := flag.String("profile", "", "configuration profile")
+	noCache := flag.Bool("no-cache", false, "don't use the response cache")
+	flag.Parse()
+
+	if *verbose && *quiet {
+		fmt.Fprintln(os.Stderr, "-verbose and -quiet can't be used together")
+		os.Exit(2)
+	}
 
-	anilistCode, _ := anilist.GetAccessCode()
-	anilistData, err := anilist.GetUserData("CrystalBullet", &anilistCode)
+	logLevel := slog.LevelInfo
+	if *verbose {
+		logLevel = slog.LevelDebug
+	} else if *quiet {
+		logLevel = slog.LevelWarn
+	}
+
+	if err := utils.SetupLogger(os.Stderr, logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := config.SetProfile(*profile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	if err != nil {
-		panic(err)
+	if *noCache {
+		config.DisableCache()
 	}
 
-	malCode, _ := mal.GetAccessCode()
-	malData, err := mal.GetUserData(malCode)
+	command := "sync"
+	args := flag.Args()
 
-	if err != nil {
-		panic(err)
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
 	}
 
-	mal.SyncData(malCode, anilistData, malData)
-	// jsonData, _ := json.MarshalIndent(res, "", " ")
+	ctx, stop := interruptContext()
+	defer stop()
 
-	// os.WriteFile("lala.json", jsonData, 0644)
+	switch command {
+	case "login":
+		runLogin(ctx, args)
+	case "sync":
+		runSync(ctx, args)
+	case "daemon":
+		runDaemon(ctx, args)
+	case "serve":
+		runServe(ctx, args)
+	case "stats":
+		runStats(ctx, args)
+	case "export":
+		runExport(ctx, args)
+	case "history":
+		runHistory(args)
+	case "unmapped":
+		runUnmapped(ctx, args)
+	case "mapping":
+		runMapping(args)
+	case "exclude":
+		runExclude(args)
+	case "xref":
+		runXref(args)
+	case "undo":
+		runUndo(ctx, args)
+	case "restore":
+		runRestore(ctx, args)
+	case "cache":
+		runCache(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// cancels the returned context on the first SIGINT or SIGTERM so in-flight work can wrap up,
+// a second signal exits immediately
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+
+		slog.Warn("Interrupted, finishing in-flight requests. Press Ctrl-C again to exit immediately")
+		cancel()
+
+		<-signals
+		os.Exit(130)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
 }
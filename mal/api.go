@@ -1,9 +1,13 @@
 package mal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"ipmanlk/ani2mal/cache"
 	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -11,8 +15,6 @@ import (
 	"time"
 )
 
-const malApiUrl = "https://api.myanimelist.net/v2"
-
 // Media status for each MAL API status
 var mediaStatuses = map[string]models.MediaStatus{
 	"plan_to_watch": models.MediaStatusPlanning,
@@ -33,81 +35,124 @@ var malStatuses = map[models.MediaStatus]string{
 	models.MediaStatusDropped:   "dropped",
 }
 
-func GetUserData(bearerToken string) (*models.SourceData, error) {
-	malAnime, err := getList(models.MAL_ANIME_LIST, bearerToken)
-	if err != nil {
-		return nil, &models.AppError{
-			Message: "Failed to fetch MAL Anime List",
-			Err:     err,
+func (c *Client) GetUserData(ctx context.Context, bearerToken string) (*models.SourceData, error) {
+	var malAnime, malManga *models.MalListRes
+
+	// both lists are fetched at the same time, a failure cancels the other request
+	group, ctx := utils.NewGroup(ctx)
+
+	group.Go(func() error {
+		var err error
+		malAnime, err = c.getList(ctx, models.MAL_ANIME_LIST, bearerToken)
+		if err != nil {
+			return &models.AppError{
+				Message: "Failed to fetch MAL Anime List",
+				Err:     err,
+			}
 		}
-	}
+		return nil
+	})
 
-	malManga, err := getList(models.MAL_MANGA_LIST, bearerToken)
-	if err != nil {
-		return nil, &models.AppError{
-			Message: "Failed to fetch MAL Manga List",
-			Err:     err,
+	group.Go(func() error {
+		var err error
+		malManga, err = c.getList(ctx, models.MAL_MANGA_LIST, bearerToken)
+		if err != nil {
+			return &models.AppError{
+				Message: "Failed to fetch MAL Manga List",
+				Err:     err,
+			}
 		}
+		return nil
+	})
+
+	if err := group.Wait(); err != nil {
+		return nil, err
 	}
 
-	stats := models.SourceStats{}
-	entriesMap := make(map[int]models.Media)
-	formattedAnime := formatListResponse(malAnime, models.MAL_ANIME_LIST, &stats, entriesMap)
-	formattedManga := formatListResponse(malManga, models.MAL_MANGA_LIST, &stats, entriesMap)
+	entriesMap := make(map[models.MediaKey]models.Media)
+	formattedAnime := formatListResponse(malAnime, models.MAL_ANIME_LIST, entriesMap)
+	formattedManga := formatListResponse(malManga, models.MAL_MANGA_LIST, entriesMap)
 
 	return &models.SourceData{
-		Stats:    stats,
 		MediaMap: entriesMap,
 		Anime:    formattedAnime,
 		Manga:    formattedManga,
 	}, nil
 }
 
-func UpdateAnime(bearerToken string, entry models.Media) error {
-	requestUrl := fmt.Sprintf("%s/anime/%d/my_list_status", malApiUrl, entry.ID)
+func (c *Client) UpdateAnime(ctx context.Context, bearerToken string, entry models.Media) error {
+	requestUrl := fmt.Sprintf("%s/anime/%d/my_list_status", c.apiUrl, entry.ID)
 
 	data := url.Values{}
 	data.Set("status", getMalStatus(entry.Status, models.MediaTypeAnime))
 	data.Set("num_watched_episodes", strconv.Itoa(entry.Progress))
 	data.Set("score", strconv.Itoa(entry.Score))
+	setOptionalFields(data, entry)
 
-	return sendPutRequest(requestUrl, bearerToken, data)
+	return c.sendPutRequest(ctx, requestUrl, bearerToken, data)
 }
 
-func DeleteAnime(bearerToken string, entry models.Media) error {
-	url := fmt.Sprintf("%s/anime/%d/my_list_status", malApiUrl, entry.ID)
-	return sendDeleteRequest(url, bearerToken)
+func (c *Client) DeleteAnime(ctx context.Context, bearerToken string, entry models.Media) error {
+	url := fmt.Sprintf("%s/anime/%d/my_list_status", c.apiUrl, entry.ID)
+	return c.sendDeleteRequest(ctx, url, bearerToken)
 }
 
-func UpdateManga(bearerToken string, entry models.Media) error {
+func (c *Client) UpdateManga(ctx context.Context, bearerToken string, entry models.Media) error {
 	data := url.Values{}
 	data.Set("status", getMalStatus(entry.Status, models.MediaTypeManga))
 	data.Set("num_chapters_read", strconv.Itoa(entry.Progress))
 	data.Set("score", strconv.Itoa(entry.Score))
-	requestUrl := fmt.Sprintf("%s/manga/%d/my_list_status", malApiUrl, entry.ID)
-	return sendPutRequest(requestUrl, bearerToken, data)
+	setOptionalFields(data, entry)
+	requestUrl := fmt.Sprintf("%s/manga/%d/my_list_status", c.apiUrl, entry.ID)
+	return c.sendPutRequest(ctx, requestUrl, bearerToken, data)
+}
+
+// empty dates and notes are left out, MAL keeps its current values for fields that are not sent
+func setOptionalFields(data url.Values, entry models.Media) {
+	if entry.StartDate != "" {
+		data.Set("start_date", entry.StartDate)
+	}
+	if entry.FinishDate != "" {
+		data.Set("finish_date", entry.FinishDate)
+	}
+	if entry.Notes != "" {
+		data.Set("comments", entry.Notes)
+	}
 }
 
-func DeleteManga(bearerToken string, entry models.Media) error {
-	url := fmt.Sprintf("%s/manga/%d/my_list_status", malApiUrl, entry.ID)
-	return sendDeleteRequest(url, bearerToken)
+func (c *Client) DeleteManga(ctx context.Context, bearerToken string, entry models.Media) error {
+	url := fmt.Sprintf("%s/manga/%d/my_list_status", c.apiUrl, entry.ID)
+	return c.sendDeleteRequest(ctx, url, bearerToken)
 }
 
-func getList(malListType models.MalListType, bearerToken string) (*models.MalListRes, error) {
+func (c *Client) getList(ctx context.Context, malListType models.MalListType, bearerToken string) (*models.MalListRes, error) {
+	groupCtx := cache.Group(cache.Cacheable(ctx))
+	list, err := c.getListPages(groupCtx, malListType, bearerToken)
+
+	// pages cached at different times could miss entries that moved between them
+	if err == nil && !cache.Consistent(groupCtx) {
+		slog.DebugContext(ctx, "Cached MAL list pages are out of date, fetching the whole list")
+		list, err = c.getListPages(cache.Refresh(cache.Cacheable(ctx)), malListType, bearerToken)
+	}
+
+	return list, err
+}
+
+func (c *Client) getListPages(ctx context.Context, malListType models.MalListType, bearerToken string) (*models.MalListRes, error) {
 	listType := "animelist"
 
 	if malListType == models.MAL_MANGA_LIST {
 		listType = "mangalist"
 	}
 
-	baseURL := fmt.Sprintf("%s/users/@me/%s", malApiUrl, listType)
-	url := baseURL + "?fields=list_status,num_episodes,num_chapters&limit=1000&nsfw=true"
+	baseURL := fmt.Sprintf("%s/users/@me/%s", c.apiUrl, listType)
+	url := baseURL + "?fields=list_status{status,score,num_episodes_watched,num_chapters_read,is_rewatching,is_rereading,updated_at,start_date,finish_date,comments},num_episodes,num_chapters&limit=1000&nsfw=true"
 
 	var allMedia []models.MalDatum
 
 	// Loop to fetch all pages
 	for url != "" {
-		res, err := sendGetRequest(url, bearerToken)
+		res, err := c.sendGetRequest(ctx, url, bearerToken)
 
 		if err != nil {
 			return nil, &models.AppError{
@@ -115,10 +160,18 @@ func getList(malListType models.MalListType, bearerToken string) (*models.MalLis
 				Err:     err,
 			}
 		}
-		defer res.Body.Close()
+
+		// an error page would decode to an empty list and every entry would look removed
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, &models.AppError{
+				Message: fmt.Sprintf("Failed to fetch MAL list, status code: %d", res.StatusCode),
+			}
+		}
 
 		var malList models.MalListRes
 		err = json.NewDecoder(res.Body).Decode(&malList)
+		res.Body.Close()
 		if err != nil {
 			return nil, &models.AppError{
 				Message: "Failed to parse MAL list response",
@@ -138,20 +191,15 @@ func getList(malListType models.MalListType, bearerToken string) (*models.MalLis
 	return &combinedList, nil
 }
 
-func sendGetRequest(url string, bearerToken string) (*http.Response, error) {
-	timeout := 15 * time.Second
-	client := &http.Client{
-		Timeout: timeout,
-	}
-
-	req, err := http.NewRequest("GET", url, nil)
+func (c *Client) sendGetRequest(ctx context.Context, url string, bearerToken string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+bearerToken)
 
-	res, err := client.Do(req)
+	res, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -159,10 +207,8 @@ func sendGetRequest(url string, bearerToken string) (*http.Response, error) {
 	return res, nil
 }
 
-func sendPutRequest(url string, bearerToken string, data url.Values) error {
-	client := &http.Client{}
-
-	req, err := http.NewRequest("PUT", url, strings.NewReader(data.Encode()))
+func (c *Client) sendPutRequest(ctx context.Context, url string, bearerToken string, data url.Values) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, strings.NewReader(data.Encode()))
 	if err != nil {
 		return err
 	}
@@ -170,7 +216,7 @@ func sendPutRequest(url string, bearerToken string, data url.Values) error {
 	req.Header.Set("Authorization", "Bearer "+bearerToken)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	res, err := client.Do(req)
+	res, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -183,10 +229,8 @@ func sendPutRequest(url string, bearerToken string, data url.Values) error {
 	return nil
 }
 
-func sendDeleteRequest(url string, bearerToken string) error {
-	client := &http.Client{}
-
-	req, err := http.NewRequest("DELETE", url, nil)
+func (c *Client) sendDeleteRequest(ctx context.Context, url string, bearerToken string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
 		return err
 	}
@@ -194,7 +238,7 @@ func sendDeleteRequest(url string, bearerToken string) error {
 	req.Header.Set("Authorization", "Bearer "+bearerToken)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	res, err := client.Do(req)
+	res, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -207,7 +251,7 @@ func sendDeleteRequest(url string, bearerToken string) error {
 	return nil
 }
 
-func formatListResponse(list *models.MalListRes, listType models.MalListType, stats *models.SourceStats, entriesMap map[int]models.Media) []models.Media {
+func formatListResponse(list *models.MalListRes, listType models.MalListType, entriesMap map[models.MediaKey]models.Media) []models.Media {
 	formattedList := make([]models.Media, len(list.Data))
 
 	for i, item := range list.Data {
@@ -215,7 +259,6 @@ func formatListResponse(list *models.MalListRes, listType models.MalListType, st
 		progress := item.ListStatus.NumEpisodesWatched
 		length := item.Node.NumEpisodes
 		repeat := item.ListStatus.IsRewatching
-		status := mediaStatuses[item.ListStatus.Status]
 
 		if listType == models.MAL_MANGA_LIST {
 			mediaType = models.MediaTypeManga
@@ -229,28 +272,22 @@ func formatListResponse(list *models.MalListRes, listType models.MalListType, st
 			Title:    item.Node.Title,
 			Progress: progress,
 			Score:    item.ListStatus.Score,
-			Status:   status,
+			Status:   mediaStatuses[item.ListStatus.Status],
 			Repeat:   repeat,
 			Type:     mediaType,
 			Length:   length,
 		}
 
-		formattedList[i] = media
-		entriesMap[media.ID] = media
-
-		// update stats reference data
-		switch status {
-		case models.MediaStatusPlanning:
-			stats.Planning += 1
-		case models.MediaStatusPaused:
-			stats.Paused += 1
-		case models.MediaStatusCurrent:
-			stats.Current += 1
-		case models.MediaStatusDropped:
-			stats.Dropped += 1
-		case models.MediaStatusCompleted:
-			stats.Completed += 1
+		media.StartDate = item.ListStatus.StartDate
+		media.FinishDate = item.ListStatus.FinishDate
+		media.Notes = item.ListStatus.Comments
+
+		if updatedAt, err := time.Parse(time.RFC3339, item.ListStatus.UpdatedAt); err == nil {
+			media.UpdatedAt = updatedAt.Unix()
 		}
+
+		formattedList[i] = media
+		entriesMap[media.Key()] = media
 	}
 
 	return formattedList
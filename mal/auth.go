@@ -1,22 +1,24 @@
 package mal
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/metrics"
 	"ipmanlk/ani2mal/models"
 	"ipmanlk/ani2mal/utils"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 )
 
-func PerformAuth() {
+func (c *Client) PerformAuth(ctx context.Context) {
 	fmt.Print("Enter Client ID: ")
 	clientId := utils.GetStrInput()
 
@@ -25,18 +27,18 @@ func PerformAuth() {
 
 	codeVerifier, err := generateCodeVerifier()
 	if err != nil {
-		log.Fatal(err.Error())
+		utils.Fatal("Failed to generate the code verifier", "error", err)
 	}
 
-	loginURL := getAuthenticationURL(clientId, codeVerifier)
+	loginURL := c.getAuthenticationURL(clientId, codeVerifier)
 	fmt.Printf("Login URL: %s\n", loginURL)
 
 	fmt.Print("Enter the code from the login URL: ")
 	code := utils.GetStrInput()
 
-	res, err := getAccessTokenRes(clientId, clientSecret, code, codeVerifier)
+	res, err := c.getAccessTokenRes(ctx, clientId, clientSecret, code, codeVerifier)
 	if err != nil {
-		log.Fatal(err.Error())
+		utils.Fatal("Failed to get the MAL access token", "error", err)
 	}
 
 	appConfig := config.GetAppConfig()
@@ -50,26 +52,36 @@ func PerformAuth() {
 	fmt.Println("Authentication successful. Access token has been saved.")
 }
 
-func GetAccessCode() (string, error) {
-	malConfig := config.GetAppConfig().GetMalConfig()
+// Tokens are refreshed when they expire within this window so a sync never runs with an expired token
+const tokenRefreshBuffer = 20 * time.Minute
 
-	// check if token is expired or will expire soon
-	currentTime := time.Now()
-	expirationTime := currentTime.Add(time.Duration(malConfig.TokenRes.ExpiresIn) * time.Second)
-	expirationBuffer := 20 * time.Minute
+func (c *Client) GetAccessCode(ctx context.Context) (string, error) {
+	malConfig := config.GetAppConfig().GetMalConfig()
 
-	if expirationTime.After(currentTime.Add(expirationBuffer)) {
-		// token is not expired
+	if !malConfig.TokenRes.ExpiresWithin(tokenRefreshBuffer) {
 		return malConfig.TokenRes.AccessToken, nil
 	}
 
-	// token is expired and new one should be requested
-	res, err := getRefreshTokenRes(malConfig.ClientId, malConfig.ClientSecret, malConfig.TokenRes.RefreshToken)
+	res, err := c.getRefreshTokenRes(ctx, malConfig.ClientId, malConfig.ClientSecret, malConfig.TokenRes.RefreshToken)
 	if err != nil {
+		metrics.TokenRefreshes.Inc("mal", "failure")
+
+		// a token that has not expired yet is still usable, the refresh is retried on the next run
+		if !malConfig.TokenRes.IsExpired() {
+			slog.Warn("Failed to refresh the MAL access token, using the current one", "error", err)
+			return malConfig.TokenRes.AccessToken, nil
+		}
 		return "", err
 	}
 
-	// Save new token info in the Mal config
+	metrics.TokenRefreshes.Inc("mal", "success")
+	slog.Info("Refreshed the MAL access token")
+
+	// the refresh token is only replaced when a new one is issued
+	if res.RefreshToken == "" {
+		res.RefreshToken = malConfig.TokenRes.RefreshToken
+	}
+
 	malConfig.TokenRes = *res
 	config.GetAppConfig().SaveMalConfig(malConfig)
 
@@ -77,12 +89,12 @@ func GetAccessCode() (string, error) {
 }
 
 // retrieves the authentication URL with code_challenge
-func getAuthenticationURL(clientId, codeChallenge string) string {
-	return fmt.Sprintf("https://myanimelist.net/v1/oauth2/authorize?response_type=code&client_id=%s&code_challenge=%s", clientId, codeChallenge)
+func (c *Client) getAuthenticationURL(clientId, codeChallenge string) string {
+	return fmt.Sprintf("%s/authorize?response_type=code&client_id=%s&code_challenge=%s", c.oauthUrl, clientId, codeChallenge)
 }
 
 // exchanges the auth code for an access token
-func getAccessTokenRes(clientId, clientSecret, authorizationCode, codeVerifier string) (*models.TokenRes, error) {
+func (c *Client) getAccessTokenRes(ctx context.Context, clientId, clientSecret, authorizationCode, codeVerifier string) (*models.TokenRes, error) {
 	data := url.Values{}
 	data.Set("client_id", clientId)
 	data.Set("client_secret", clientSecret)
@@ -90,28 +102,35 @@ func getAccessTokenRes(clientId, clientSecret, authorizationCode, codeVerifier s
 	data.Set("code_verifier", codeVerifier)
 	data.Set("grant_type", "authorization_code")
 
-	return sendTokenRequest(data)
+	return c.sendTokenRequest(ctx, data)
 }
 
 // request a new access token using refresh token
-func getRefreshTokenRes(clientId, clientSecret, refreshToken string) (*models.TokenRes, error) {
+func (c *Client) getRefreshTokenRes(ctx context.Context, clientId, clientSecret, refreshToken string) (*models.TokenRes, error) {
 	data := url.Values{}
 	data.Set("client_id", clientId)
 	data.Set("client_secret", clientSecret)
 	data.Set("refresh_token", refreshToken)
 	data.Set("grant_type", "refresh_token")
 
-	return sendTokenRequest(data)
+	return c.sendTokenRequest(ctx, data)
 }
 
-func sendTokenRequest(data url.Values) (*models.TokenRes, error) {
-	tokenEndpoint := "https://myanimelist.net/v1/oauth2/token"
+func (c *Client) sendTokenRequest(ctx context.Context, data url.Values) (*models.TokenRes, error) {
+	tokenEndpoint := c.oauthUrl + "/token"
 
-	client := &http.Client{
-		Timeout: 15 * time.Second,
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to create the access token request",
+			Err:     err,
+		}
 	}
 
-	res, err := client.Post(tokenEndpoint, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	requestedAt := time.Now()
+	res, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, &models.AppError{
 			Message: "Failed to request the access token",
@@ -144,6 +163,8 @@ func sendTokenRequest(data url.Values) (*models.TokenRes, error) {
 		}
 	}
 
+	tokenRes.SetExpiry(requestedAt)
+
 	return &tokenRes, nil
 }
 
@@ -0,0 +1,31 @@
+package mal
+
+import (
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/models"
+	"time"
+)
+
+// snapshots the MAL list so the changes that follow can be undone
+func SaveBackup(malData *models.SourceData) *models.Backup {
+	createdAt := time.Now().UTC()
+
+	// milliseconds keep two syncs in the same second from choosing the same name
+	backup := &models.Backup{
+		Name:      createdAt.Format("20060102-150405.000") + "-mal",
+		Service:   "mal",
+		CreatedAt: createdAt.Unix(),
+		Data:      *malData,
+	}
+
+	config.GetAppConfig().SaveBackup(backup)
+
+	return backup
+}
+
+// returns the changes that bring MAL back to the state saved in a backup. Unlike a sync the backed up
+// values always win. MAL can't be asked to clear a date or notes, so dates and notes added after the
+// backup are kept, only values the backup has are restored
+func PlanRestore(backup *models.Backup, malData *models.SourceData) []models.SyncOp {
+	return planChanges(&backup.Data, malData, mergeSource)
+}
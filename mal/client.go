@@ -0,0 +1,35 @@
+package mal
+
+import (
+	"ipmanlk/ani2mal/cache"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultApiUrl   = "https://api.myanimelist.net/v2"
+	defaultOAuthUrl = "https://myanimelist.net/v1/oauth2"
+)
+
+// MAL API client, one client and its connections are shared by every request
+type Client struct {
+	httpClient *http.Client
+	apiUrl     string
+	oauthUrl   string
+}
+
+// responses to list fetches and searches are cached in store, a nil store turns caching off
+func NewClient(settings *models.Settings, store *cache.Store) (*Client, error) {
+	httpClient, err := utils.NewHTTPClient("mal", settings.HTTP, store)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		httpClient: httpClient,
+		apiUrl:     strings.TrimSuffix(utils.OrDefault(settings.Mal.APIURL, defaultApiUrl), "/"),
+		oauthUrl:   strings.TrimSuffix(utils.OrDefault(settings.Mal.OAuthURL, defaultOAuthUrl), "/"),
+	}, nil
+}
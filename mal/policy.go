@@ -0,0 +1,83 @@
+package mal
+
+import (
+	"ipmanlk/ani2mal/models"
+)
+
+// returns the entry MAL should end up with when both sides have one
+type mergeFunc func(source, target models.Media) models.Media
+
+// resolves every field with the policy configured for its media type. Repeat is not synced, see hasDesiredValues
+func newPolicyMerge(settings models.PolicySettings) mergeFunc {
+	animePolicies := settings.For(models.MediaTypeAnime)
+	mangaPolicies := settings.For(models.MediaTypeManga)
+
+	return func(source, target models.Media) models.Media {
+		policies := animePolicies
+		if source.Type == models.MediaTypeManga {
+			policies = mangaPolicies
+		}
+
+		sourceIsNewer := source.UpdatedAt >= target.UpdatedAt
+		merged := source
+
+		merged.Status = resolveField(policies.Status, source.Status, target.Status, sourceIsNewer, nil)
+		merged.Score = resolveField(policies.Score, source.Score, target.Score, sourceIsNewer, maxInt)
+		merged.Progress = resolveField(policies.Progress, source.Progress, target.Progress, sourceIsNewer, maxInt)
+		merged.StartDate = resolveField(policies.Dates, source.StartDate, target.StartDate, sourceIsNewer, maxDate)
+		merged.FinishDate = resolveField(policies.Dates, source.FinishDate, target.FinishDate, sourceIsNewer, maxDate)
+		merged.Notes = resolveField(policies.Notes, source.Notes, target.Notes, sourceIsNewer, nil)
+
+		return merged
+	}
+}
+
+// restores the backed up entry as it was
+func mergeSource(source, target models.Media) models.Media {
+	return source
+}
+
+// pickMax is nil for fields where max has no meaning, settings validation keeps it from being used there
+func resolveField[T comparable](policy models.ConflictPolicy, source, target T, sourceIsNewer bool, pickMax func(T, T) T) T {
+	var zero T
+
+	switch policy {
+	case models.PolicyPreferTarget:
+		return target
+	case models.PolicyMax:
+		if pickMax != nil {
+			return pickMax(source, target)
+		}
+	case models.PolicyLatestUpdated:
+		if !sourceIsNewer {
+			return target
+		}
+	case models.PolicyNeverOverwriteNonzero:
+		if target != zero {
+			return target
+		}
+	}
+
+	return source
+}
+
+func maxInt(a, b int) int {
+	return max(a, b)
+}
+
+// dates are YYYY-MM-DD prefixes so the later one sorts last
+func maxDate(a, b string) string {
+	return max(a, b)
+}
+
+// true when MAL already has every value the desired entry would write.
+// Empty dates and notes are never sent, so they match anything. This also means a restore can't clear them.
+// Repeat is not synced on purpose, Anilist counts rewatches while MAL only flags a rewatch in progress
+func hasDesiredValues(desired, current models.Media) bool {
+	return desired.Status == current.Status &&
+		desired.Score == current.Score &&
+		desired.Progress == current.Progress &&
+		(desired.StartDate == "" || desired.StartDate == current.StartDate) &&
+		(desired.FinishDate == "" || desired.FinishDate == current.FinishDate) &&
+		(desired.Notes == "" || desired.Notes == current.Notes)
+}
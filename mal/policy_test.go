@@ -0,0 +1,137 @@
+package mal
+
+import (
+	"ipmanlk/ani2mal/models"
+	"testing"
+)
+
+func TestResolveField(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        models.ConflictPolicy
+		source        int
+		target        int
+		sourceIsNewer bool
+		want          int
+	}{
+		{"prefer source", models.PolicyPreferSource, 3, 5, false, 3},
+		{"prefer target", models.PolicyPreferTarget, 3, 5, true, 5},
+		{"max picks target", models.PolicyMax, 3, 5, true, 5},
+		{"max picks source", models.PolicyMax, 7, 5, false, 7},
+		{"latest updated source newer", models.PolicyLatestUpdated, 3, 5, true, 3},
+		{"latest updated target newer", models.PolicyLatestUpdated, 3, 5, false, 5},
+		{"never overwrite keeps target", models.PolicyNeverOverwriteNonzero, 3, 5, true, 5},
+		{"never overwrite fills zero target", models.PolicyNeverOverwriteNonzero, 3, 0, false, 3},
+		{"unset policy prefers source", "", 3, 5, false, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveField(tt.policy, tt.source, tt.target, tt.sourceIsNewer, maxInt)
+			if got != tt.want {
+				t.Errorf("resolveField() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveFieldWithoutMax(t *testing.T) {
+	// fields without an ordering fall back to the source
+	got := resolveField(models.PolicyMax, "a", "b", false, nil)
+	if got != "a" {
+		t.Errorf("resolveField() = %q, want %q", got, "a")
+	}
+}
+
+func TestMaxDate(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"2024-01-05", "2024-02-01", "2024-02-01"},
+		{"2024-03-01", "2024-02-01", "2024-03-01"},
+		{"", "2024-02-01", "2024-02-01"},
+		{"2024", "2024-02", "2024-02"},
+	}
+
+	for _, tt := range tests {
+		if got := maxDate(tt.a, tt.b); got != tt.want {
+			t.Errorf("maxDate(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyMerge(t *testing.T) {
+	source := models.Media{ID: 1, Type: models.MediaTypeAnime, Status: models.MediaStatusCompleted, Score: 8, Progress: 30, StartDate: "2024-01-05", Notes: "anilist", UpdatedAt: 200}
+	target := models.Media{ID: 1, Type: models.MediaTypeAnime, Status: models.MediaStatusCurrent, Score: 9, Progress: 10, Length: 24, StartDate: "2024-01-01", Notes: "mal", UpdatedAt: 100}
+
+	tests := []struct {
+		name     string
+		settings models.PolicySettings
+		source   models.Media
+		want     models.Media
+	}{
+		{
+			name:     "defaults keep MAL dates and notes",
+			settings: models.PolicySettings{},
+			source:   source,
+			want:     models.Media{Status: models.MediaStatusCompleted, Score: 8, Progress: 30, StartDate: "2024-01-01", Notes: "mal"},
+		},
+		{
+			name:     "max score and progress",
+			settings: models.PolicySettings{Default: models.FieldPolicies{Score: models.PolicyMax, Progress: models.PolicyMax}},
+			source:   source,
+			want:     models.Media{Status: models.MediaStatusCompleted, Score: 9, Progress: 30, StartDate: "2024-01-01", Notes: "mal"},
+		},
+		{
+			name:     "anime override wins over default",
+			settings: models.PolicySettings{Default: models.FieldPolicies{Notes: models.PolicyPreferTarget}, Anime: models.FieldPolicies{Notes: models.PolicyPreferSource, Dates: models.PolicyPreferSource}},
+			source:   source,
+			want:     models.Media{Status: models.MediaStatusCompleted, Score: 8, Progress: 30, StartDate: "2024-01-05", Notes: "anilist"},
+		},
+		{
+			name:     "latest updated picks MAL when it changed last",
+			settings: models.PolicySettings{Default: models.FieldPolicies{Status: models.PolicyLatestUpdated}},
+			source:   func() models.Media { m := source; m.UpdatedAt = 50; return m }(),
+			want:     models.Media{Status: models.MediaStatusCurrent, Score: 8, Progress: 30, StartDate: "2024-01-01", Notes: "mal"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newPolicyMerge(tt.settings)(tt.source, target)
+
+			if got.Status != tt.want.Status || got.Score != tt.want.Score || got.Progress != tt.want.Progress ||
+				got.StartDate != tt.want.StartDate || got.Notes != tt.want.Notes {
+				t.Errorf("merge() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasDesiredValues(t *testing.T) {
+	current := models.Media{Status: models.MediaStatusCurrent, Score: 7, Progress: 3, StartDate: "2024-01-05", FinishDate: "2024-02-01", Notes: "note"}
+
+	tests := []struct {
+		name    string
+		desired models.Media
+		want    bool
+	}{
+		{"same values", current, true},
+		{"empty dates and notes match anything", models.Media{Status: models.MediaStatusCurrent, Score: 7, Progress: 3}, true},
+		{"different status", func() models.Media { m := current; m.Status = models.MediaStatusCompleted; return m }(), false},
+		{"different score", func() models.Media { m := current; m.Score = 8; return m }(), false},
+		{"different progress", func() models.Media { m := current; m.Progress = 4; return m }(), false},
+		{"different start date", func() models.Media { m := current; m.StartDate = "2024-01-06"; return m }(), false},
+		{"different finish date", func() models.Media { m := current; m.FinishDate = "2024-02-02"; return m }(), false},
+		{"different notes", func() models.Media { m := current; m.Notes = "other"; return m }(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasDesiredValues(tt.desired, current); got != tt.want {
+				t.Errorf("hasDesiredValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package mal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"ipmanlk/ani2mal/cache"
+	"ipmanlk/ani2mal/models"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Minimum confidence for a candidate to be accepted without asking the user
+const AutoMatchConfidence = 0.9
+
+// MAL rejects search queries outside of this length range
+const (
+	minSearchQueryLength = 3
+	maxSearchQueryLength = 64
+)
+
+// MAL media types that correspond to each Anilist format
+var malMediaTypes = map[string][]string{
+	"TV":       {"tv"},
+	"TV_SHORT": {"tv"},
+	"MOVIE":    {"movie"},
+	"SPECIAL":  {"special", "tv_special"},
+	"OVA":      {"ova"},
+	"ONA":      {"ona"},
+	"MUSIC":    {"music"},
+	"MANGA":    {"manga", "manhwa", "manhua", "doujinshi", "oel"},
+	"NOVEL":    {"light_novel", "novel"},
+	"ONE_SHOT": {"one_shot"},
+}
+
+// searches MAL by title and returns the raw search results
+func (c *Client) SearchMedia(ctx context.Context, bearerToken string, mediaType models.MediaType, query string) (*models.MalSearchRes, error) {
+	query = strings.TrimSpace(query)
+	if runes := []rune(query); len(runes) > maxSearchQueryLength {
+		query = string(runes[:maxSearchQueryLength])
+	}
+
+	if len([]rune(query)) < minSearchQueryLength {
+		return &models.MalSearchRes{}, nil
+	}
+
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("limit", "10")
+	params.Set("nsfw", "true")
+	params.Set("fields", "alternative_titles,media_type,start_date,num_episodes,num_chapters")
+
+	requestUrl := fmt.Sprintf("%s/%s?%s", c.apiUrl, mediaType, params.Encode())
+
+	res, err := c.sendGetRequest(cache.Cacheable(ctx), requestUrl, bearerToken)
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to search MAL",
+			Err:     err,
+		}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, &models.AppError{
+			Message: fmt.Sprintf("Failed to search MAL, status code: %d", res.StatusCode),
+		}
+	}
+
+	var searchRes models.MalSearchRes
+	err = json.NewDecoder(res.Body).Decode(&searchRes)
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to parse MAL search response",
+			Err:     err,
+		}
+	}
+
+	return &searchRes, nil
+}
+
+// searches MAL for an unmapped entry and returns candidates, best match first
+func (c *Client) FindCandidates(ctx context.Context, bearerToken string, entry models.UnmappedMedia) ([]models.MalCandidate, error) {
+	titles := append([]string{entry.Media.Title}, entry.AltTitles...)
+	seen := make(map[int]bool)
+	candidates := make([]models.MalCandidate, 0)
+
+	for _, title := range titles {
+		searchRes, err := c.SearchMedia(ctx, bearerToken, entry.Media.Type, title)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, datum := range searchRes.Data {
+			if seen[datum.Node.ID] {
+				continue
+			}
+			seen[datum.Node.ID] = true
+
+			candidates = append(candidates, models.MalCandidate{
+				ID:         datum.Node.ID,
+				Title:      datum.Node.Title,
+				MediaType:  datum.Node.MediaType,
+				Year:       getYear(datum.Node.StartDate),
+				Confidence: rankCandidate(entry, titles, &datum.Node),
+			})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+
+	return candidates, nil
+}
+
+// returns the best candidate when it is confident enough to be accepted without asking
+func GetAutoMatch(candidates []models.MalCandidate) (models.MalCandidate, bool) {
+	if len(candidates) == 0 || candidates[0].Confidence < AutoMatchConfidence {
+		return models.MalCandidate{}, false
+	}
+
+	// two equally good candidates is usually a sequel or a remake
+	if len(candidates) > 1 && candidates[0].Confidence-candidates[1].Confidence < 0.05 {
+		return models.MalCandidate{}, false
+	}
+
+	return candidates[0], true
+}
+
+// weights title similarity, format and release year into a score between 0 and 1
+func rankCandidate(entry models.UnmappedMedia, titles []string, node *models.MalSearchNode) float64 {
+	nodeTitles := append([]string{node.Title, node.AlternativeTitles.En, node.AlternativeTitles.Ja}, node.AlternativeTitles.Synonyms...)
+
+	titleScore := 0.0
+	for _, title := range titles {
+		for _, nodeTitle := range nodeTitles {
+			if nodeTitle == "" {
+				continue
+			}
+			if similarity := getTitleSimilarity(title, nodeTitle); similarity > titleScore {
+				titleScore = similarity
+			}
+		}
+	}
+
+	formatScore := 0.5
+	if malTypes, ok := malMediaTypes[entry.Format]; ok {
+		formatScore = 0
+		for _, malType := range malTypes {
+			if malType == node.MediaType {
+				formatScore = 1
+				break
+			}
+		}
+	}
+
+	yearScore := 0.5
+	if year := getYear(node.StartDate); entry.Year != 0 && year != 0 {
+		switch diff := entry.Year - year; {
+		case diff == 0:
+			yearScore = 1
+		case diff == 1 || diff == -1:
+			yearScore = 0.5
+		default:
+			yearScore = 0
+		}
+	}
+
+	return 0.7*titleScore + 0.15*formatScore + 0.15*yearScore
+}
+
+// Sørensen–Dice coefficient over character bigrams of normalized titles
+func getTitleSimilarity(a, b string) float64 {
+	a, b = normalizeTitle(a), normalizeTitle(b)
+
+	if a == b {
+		return 1
+	}
+
+	aBigrams, bBigrams := getBigrams(a), getBigrams(b)
+	if len(aBigrams) == 0 || len(bBigrams) == 0 {
+		return 0
+	}
+
+	counts := make(map[string]int)
+	for _, bigram := range aBigrams {
+		counts[bigram]++
+	}
+
+	matches := 0
+	for _, bigram := range bBigrams {
+		if counts[bigram] > 0 {
+			counts[bigram]--
+			matches++
+		}
+	}
+
+	return float64(2*matches) / float64(len(aBigrams)+len(bBigrams))
+}
+
+func normalizeTitle(title string) string {
+	var builder strings.Builder
+
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			builder.WriteRune(r)
+		} else {
+			builder.WriteRune(' ')
+		}
+	}
+
+	return strings.Join(strings.Fields(builder.String()), " ")
+}
+
+func getBigrams(value string) []string {
+	runes := []rune(value)
+	bigrams := make([]string, 0, len(runes))
+
+	for i := 0; i+1 < len(runes); i++ {
+		bigrams = append(bigrams, string(runes[i:i+2]))
+	}
+
+	return bigrams
+}
+
+// MAL dates can be "2006", "2006-10" or "2006-10-04"
+func getYear(date string) int {
+	if len(date) < 4 {
+		return 0
+	}
+
+	year, err := strconv.Atoi(date[:4])
+	if err != nil {
+		return 0
+	}
+
+	return year
+}
@@ -0,0 +1,139 @@
+package mal
+
+import (
+	"ipmanlk/ani2mal/models"
+	"math"
+	"testing"
+)
+
+func TestRankCandidate(t *testing.T) {
+	entry := models.UnmappedMedia{Format: "TV", Year: 2013}
+	titles := []string{"Shingeki no Kyojin", "Attack on Titan"}
+
+	tests := []struct {
+		name string
+		node models.MalSearchNode
+		want float64
+	}{
+		{
+			name: "same title, format and year",
+			node: models.MalSearchNode{Title: "Shingeki no Kyojin", MediaType: "tv", StartDate: "2013-04-07"},
+			want: 1,
+		},
+		{
+			name: "English alternative title",
+			node: models.MalSearchNode{Title: "Other", AlternativeTitles: models.MalAlternativeTitles{En: "Attack on Titan!"}, MediaType: "tv", StartDate: "2013"},
+			want: 1,
+		},
+		{
+			name: "synonym",
+			node: models.MalSearchNode{Title: "Other", AlternativeTitles: models.MalAlternativeTitles{Synonyms: []string{"attack on titan"}}, MediaType: "tv", StartDate: "2013"},
+			want: 1,
+		},
+		{
+			name: "different format",
+			node: models.MalSearchNode{Title: "Shingeki no Kyojin", MediaType: "movie", StartDate: "2013"},
+			want: 0.85,
+		},
+		{
+			name: "year off by one",
+			node: models.MalSearchNode{Title: "Shingeki no Kyojin", MediaType: "tv", StartDate: "2014-01"},
+			want: 0.925,
+		},
+		{
+			name: "year far off",
+			node: models.MalSearchNode{Title: "Shingeki no Kyojin", MediaType: "tv", StartDate: "2020"},
+			want: 0.85,
+		},
+		{
+			name: "unknown year",
+			node: models.MalSearchNode{Title: "Shingeki no Kyojin", MediaType: "tv"},
+			want: 0.925,
+		},
+		{
+			name: "unrelated title",
+			node: models.MalSearchNode{Title: "xyz", MediaType: "tv", StartDate: "2013"},
+			want: 0.3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rankCandidate(entry, titles, &tt.node)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("rankCandidate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankCandidateUnknownFormat(t *testing.T) {
+	entry := models.UnmappedMedia{Format: "", Year: 0}
+	node := models.MalSearchNode{Title: "Shingeki no Kyojin", MediaType: "tv", StartDate: "2013"}
+
+	got := rankCandidate(entry, []string{"Shingeki no Kyojin"}, &node)
+	if want := 0.85; math.Abs(got-want) > 1e-9 {
+		t.Errorf("rankCandidate() = %v, want %v", got, want)
+	}
+}
+
+func TestRankCandidateOrdersSequels(t *testing.T) {
+	entry := models.UnmappedMedia{Format: "TV", Year: 2013}
+	titles := []string{"Shingeki no Kyojin"}
+
+	exact := rankCandidate(entry, titles, &models.MalSearchNode{Title: "Shingeki no Kyojin", MediaType: "tv", StartDate: "2013"})
+	sequel := rankCandidate(entry, titles, &models.MalSearchNode{Title: "Shingeki no Kyojin Season 2", MediaType: "tv", StartDate: "2017"})
+
+	if sequel >= exact {
+		t.Errorf("sequel ranked %v, want less than the exact match %v", sequel, exact)
+	}
+}
+
+func TestGetAutoMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []models.MalCandidate
+		wantID     int
+		wantOk     bool
+	}{
+		{
+			name:       "no candidates",
+			candidates: nil,
+		},
+		{
+			name:       "single confident candidate",
+			candidates: []models.MalCandidate{{ID: 1, Confidence: 0.95}},
+			wantID:     1,
+			wantOk:     true,
+		},
+		{
+			name:       "exactly at the threshold",
+			candidates: []models.MalCandidate{{ID: 1, Confidence: AutoMatchConfidence}},
+			wantID:     1,
+			wantOk:     true,
+		},
+		{
+			name:       "below the threshold",
+			candidates: []models.MalCandidate{{ID: 1, Confidence: 0.89}},
+		},
+		{
+			name:       "clear winner",
+			candidates: []models.MalCandidate{{ID: 1, Confidence: 1}, {ID: 2, Confidence: 0.9}},
+			wantID:     1,
+			wantOk:     true,
+		},
+		{
+			name:       "two close candidates",
+			candidates: []models.MalCandidate{{ID: 1, Confidence: 1}, {ID: 2, Confidence: 0.97}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := GetAutoMatch(tt.candidates)
+			if ok != tt.wantOk || got.ID != tt.wantID {
+				t.Errorf("GetAutoMatch() = %d, %v, want %d, %v", got.ID, ok, tt.wantID, tt.wantOk)
+			}
+		})
+	}
+}
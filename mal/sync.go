@@ -1,102 +1,331 @@
 package mal
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"ipmanlk/ani2mal/config"
 	"ipmanlk/ani2mal/models"
-	"log"
+	"log/slog"
+	"sort"
+	"time"
 )
 
-func SyncData(malBearerToken string, anilistData, malData *models.SourceData) {
-	addedMedia := make([]models.Media, 0)
-	removedMedia := make([]models.Media, 0)
-	updatedMedia := make([]models.Media, 0)
+var ErrUnfinishedSync = errors.New("the previous sync did not finish")
 
-	updatedStuff := make([]map[string]models.Media, 0)
+// compares both sources and returns the changes needed for MAL to match Anilist.
+// Entries on both sides are merged field by field with the given policies
+func PlanSync(anilistData, malData *models.SourceData, policies models.PolicySettings, exclusions map[models.MediaKey]models.Exclusion) []models.SyncOp {
+	plan := planChanges(anilistData, malData, newPolicyMerge(policies))
+	return removeExcluded(plan, withIgnored(exclusions, anilistData.Ignored))
+}
 
-	for malId, anilistMedia := range anilistData.MediaMap {
-		// entry exist in both media maps
-		if _, ok := malData.MediaMap[malId]; ok {
-			// check if entry is the same
-			if isMediaEqual(anilistMedia, malData.MediaMap[malId]) {
-				continue
-			}
-			// otherwise entry is modified
-			updatedMedia = append(updatedMedia, anilistMedia)
+// plans changes for the Anilist entries updated since the last sync. Nothing is deleted,
+// entries removed from Anilist are only noticed by a full sync
+func PlanIncrementalSync(changedData, malData *models.SourceData, policies models.PolicySettings, exclusions map[models.MediaKey]models.Exclusion) []models.SyncOp {
+	plan := planUpdates(changedData, malData, newPolicyMerge(policies))
+	sortPlan(plan)
+	return removeExcluded(plan, exclusions)
+}
 
-			updatedStuff = append(updatedStuff, map[string]models.Media{
-				"mal":     malData.MediaMap[malId],
-				"anilist": anilistMedia,
-			})
+// ignored mappings keep their MAL entry as it is, the same as an exclusion
+func withIgnored(exclusions map[models.MediaKey]models.Exclusion, ignored []models.MediaKey) map[models.MediaKey]models.Exclusion {
+	if len(ignored) == 0 {
+		return exclusions
+	}
 
-			continue
+	combined := make(map[models.MediaKey]models.Exclusion, len(exclusions)+len(ignored))
+	for key, exclusion := range exclusions {
+		combined[key] = exclusion
+	}
+	for _, key := range ignored {
+		if _, ok := combined[key]; !ok {
+			combined[key] = models.Exclusion{Type: key.Type, ID: key.ID}
+		}
+	}
+	return combined
+}
 
-		} else {
-			// entry does not exist in mal
-			addedMedia = append(addedMedia, anilistMedia)
+// drops the operations on excluded entries
+func removeExcluded(plan []models.SyncOp, exclusions map[models.MediaKey]models.Exclusion) []models.SyncOp {
+	if len(exclusions) == 0 {
+		return plan
+	}
+
+	kept := make([]models.SyncOp, 0, len(plan))
+	for _, op := range plan {
+		if _, ok := exclusions[op.Media.Key()]; ok {
+			slog.Debug("Skipped excluded entry", "op", op.Kind, "type", op.Media.Type, "id", op.Media.ID, "title", op.Media.Title)
+			continue
 		}
+		kept = append(kept, op)
 	}
 
+	return kept
+}
+
+// returns the changes that turn target into source, entries on both sides are merged first
+func planChanges(source, target *models.SourceData, merge mergeFunc) []models.SyncOp {
+	plan := planUpdates(source, target, merge)
+
 	// removed media should be checked against anilistData
-	for malId, malMedia := range malData.MediaMap {
-		if _, ok := anilistData.MediaMap[malId]; !ok {
+	for key, malMedia := range target.MediaMap {
+		if _, ok := source.MediaMap[key]; !ok {
 			// entry does not exist in anilist
-			removedMedia = append(removedMedia, malMedia)
+			previous := malMedia
+			plan = append(plan, models.SyncOp{Kind: models.SyncOpDelete, Media: malMedia, Previous: &previous})
 		}
 	}
 
-	log.Printf("Added Media: %d", len(addedMedia))
-	log.Printf("Removed Media: %d", len(removedMedia))
-	log.Printf("Updated Media: %d", len(updatedMedia))
+	sortPlan(plan)
+
+	return plan
+}
 
-	// Sync data
-	log.Printf("Syncing: Added Media")
+// returns the adds and updates that bring the source entries to target
+func planUpdates(source, target *models.SourceData, merge mergeFunc) []models.SyncOp {
+	plan := make([]models.SyncOp, 0)
 
-	for _, media := range append(addedMedia, updatedMedia...) {
-		if media.Type == models.MediaTypeAnime {
-			err := UpdateAnime(malBearerToken, media)
-			if err != nil {
-				fmt.Printf("Failed to update anime %v\n", err)
-				continue
-			}
-		} else {
-			err := UpdateManga(malBearerToken, media)
-			if err != nil {
-				fmt.Printf("Failed to update manga %v\n", err)
-				continue
-			}
-			fmt.Printf("Updated: %s\n", media.Title)
+	for key, anilistMedia := range source.MediaMap {
+		malMedia, ok := target.MediaMap[key]
+
+		// entry does not exist in mal
+		if !ok {
+			plan = append(plan, models.SyncOp{Kind: models.SyncOpAdd, Media: anilistMedia})
+			continue
+		}
+
+		desired := merge(anilistMedia, malMedia)
+		if hasDesiredValues(desired, malMedia) {
+			continue
+		}
+
+		plan = append(plan, models.SyncOp{Kind: models.SyncOpUpdate, Media: desired, Previous: &malMedia})
+	}
+
+	return plan
+}
+
+func sortPlan(plan []models.SyncOp) {
+	// adds and updates go before deletes, same as they always have
+	kindOrder := map[models.SyncOpKind]int{models.SyncOpAdd: 0, models.SyncOpUpdate: 1, models.SyncOpDelete: 2}
+	sort.SliceStable(plan, func(i, j int) bool {
+		if kindOrder[plan[i].Kind] != kindOrder[plan[j].Kind] {
+			return kindOrder[plan[i].Kind] < kindOrder[plan[j].Kind]
+		}
+		return plan[i].Media.Key().String() < plan[j].Media.Key().String()
+	})
+}
+
+// splits off the operations whose MAL entry changed after the plan was made from an older MAL list,
+// applying them could overwrite a change made on MAL in the meantime
+func RemoveStale(plan []models.SyncOp, malData *models.SourceData) (current, stale []models.SyncOp) {
+	current = make([]models.SyncOp, 0, len(plan))
+	stale = make([]models.SyncOp, 0)
+
+	for _, op := range plan {
+		if isOpStale(op, malData) {
+			stale = append(stale, op)
+			continue
+		}
+		current = append(current, op)
+	}
+
+	return current, stale
+}
+
+func isOpStale(op models.SyncOp, malData *models.SourceData) bool {
+	malMedia, ok := malData.MediaMap[op.Media.Key()]
+
+	if op.Kind == models.SyncOpAdd {
+		return ok
+	}
+
+	return !ok || op.Previous == nil || malMedia != *op.Previous
+}
+
+// applies a plan to MAL. The current MAL list is backed up and the plan is
+// written to the journal before the first change is sent. A plan is never applied over
+// the journal of an unfinished sync, it has to be resumed or discarded first
+func (c *Client) ApplyPlan(ctx context.Context, malBearerToken string, plan []models.SyncOp, malData *models.SourceData) (*models.SyncResult, error) {
+	if journal := config.GetAppConfig().GetSyncJournal(); journal != nil && !journal.IsComplete() {
+		return nil, &models.AppError{
+			Message: "Refusing to apply changes, resume the unfinished sync with `ani2mal sync -resume` or plan it again with `ani2mal sync -full`",
+			Err:     ErrUnfinishedSync,
+		}
+	}
+
+	counts := make(map[models.SyncOpKind]int)
+	for _, op := range plan {
+		counts[op.Kind]++
+	}
+
+	slog.Info("Planned sync", "add", counts[models.SyncOpAdd], "update", counts[models.SyncOpUpdate], "delete", counts[models.SyncOpDelete])
+
+	if len(plan) > 0 {
+		backup := SaveBackup(malData)
+		slog.Info("Saved backup of the MAL list", "backup", backup.Name)
+	}
+
+	// the whole plan is recorded before anything is sent to MAL
+	journal := &models.SyncJournal{
+		StartedAt: time.Now().Unix(),
+		Entries:   make([]models.SyncJournalEntry, len(plan)),
+	}
+	for i, op := range plan {
+		journal.Entries[i] = models.SyncJournalEntry{Op: op, State: models.SyncOpPending}
+	}
+	config.GetAppConfig().SaveSyncJournal(journal)
+
+	result := &models.SyncResult{StartedAt: time.Now(), Entries: make([]models.SyncEntryResult, 0)}
+	c.applyJournal(ctx, malBearerToken, journal, result)
+
+	return result, nil
+}
+
+// drops the journal of an unfinished sync, for a full sync that planned its remaining changes again.
+// Returns true when there was one
+func DiscardUnfinishedSync() bool {
+	appConfig := config.GetAppConfig()
+
+	journal := appConfig.GetSyncJournal()
+	if journal == nil || journal.IsComplete() {
+		return false
+	}
+
+	appConfig.RemoveSyncJournal()
+	return true
+}
+
+// continues an unfinished sync. Entries are checked against the current MAL list first
+// so changes that reached MAL before the sync stopped are not sent again
+func (c *Client) ResumeSync(ctx context.Context, malBearerToken string, journal *models.SyncJournal, malData *models.SourceData) *models.SyncResult {
+	result := &models.SyncResult{StartedAt: time.Now(), Entries: make([]models.SyncEntryResult, 0)}
+
+	for i := range journal.Entries {
+		entry := &journal.Entries[i]
+
+		if entry.State == models.SyncOpDone {
+			continue
 		}
+
+		if isOpApplied(entry.Op, malData) {
+			entry.State = models.SyncOpDone
+			entry.Error = ""
+			result.Entries = append(result.Entries, newEntryResult(entry.Op, models.SyncOutcomeAlreadyApplied))
+			continue
+		}
+
+		entry.State = models.SyncOpPending
 	}
 
-	for _, media := range removedMedia {
-		if media.Type == models.MediaTypeAnime {
-			err := DeleteAnime(malBearerToken, media)
-			if err != nil {
-				fmt.Printf("Failed to delete anime %v\n", err)
-				continue
-			}
+	config.GetAppConfig().SaveSyncJournal(journal)
+	backup := SaveBackup(malData)
+	slog.Info("Saved backup of the MAL list", "backup", backup.Name)
+
+	c.applyJournal(ctx, malBearerToken, journal, result)
+
+	return result
+}
+
+// applies every pending journal entry, the journal is saved after each one
+func (c *Client) applyJournal(ctx context.Context, malBearerToken string, journal *models.SyncJournal, result *models.SyncResult) {
+	appConfig := config.GetAppConfig()
+
+	for i := range journal.Entries {
+		entry := &journal.Entries[i]
+
+		if entry.State != models.SyncOpPending {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			result.Entries = append(result.Entries, newEntryResult(entry.Op, models.SyncOutcomeNotApplied))
+			continue
+		}
+
+		startedAt := time.Now()
+		err := c.applyOp(ctx, malBearerToken, entry.Op)
+		entryResult := newEntryResult(entry.Op, models.SyncOutcomeApplied)
+		entryResult.DurationMs = time.Since(startedAt).Milliseconds()
+
+		if err != nil {
+			slog.Error("Failed to apply change", "op", entry.Op.Kind, "type", entry.Op.Media.Type, "id", entry.Op.Media.ID, "title", entry.Op.Media.Title, "error", err)
+			entry.State = models.SyncOpFailed
+			entry.Error = err.Error()
+			entryResult.Outcome = models.SyncOutcomeFailed
+			entryResult.Error = err.Error()
 		} else {
-			err := DeleteManga(malBearerToken, media)
-			if err != nil {
-				fmt.Printf("Failed to delete manga %v\n", err)
-				continue
-			}
-			fmt.Printf("Deleted: %s\n", media.Title)
+			slog.Info(pastTense[entry.Op.Kind], "type", entry.Op.Media.Type, "id", entry.Op.Media.ID, "title", entry.Op.Media.Title, "duration_ms", entryResult.DurationMs)
+			entry.State = models.SyncOpDone
 		}
+
+		result.Entries = append(result.Entries, entryResult)
+		appConfig.SaveSyncJournal(journal)
+	}
+
+	result.Interrupted = ctx.Err() != nil
+	result.FinishedAt = time.Now()
+
+	if !result.Interrupted {
+		journal.FinishedAt = result.FinishedAt.Unix()
+		appConfig.SaveSyncJournal(journal)
+	}
+}
+
+var pastTense = map[models.SyncOpKind]string{
+	models.SyncOpAdd:    "Added media",
+	models.SyncOpUpdate: "Updated media",
+	models.SyncOpDelete: "Deleted media",
+}
+
+func newEntryResult(op models.SyncOp, outcome models.SyncOutcome) models.SyncEntryResult {
+	entryResult := models.SyncEntryResult{
+		Op:      op.Kind,
+		Media:   op.Media,
+		Outcome: outcome,
+	}
+
+	if op.Kind != models.SyncOpDelete {
+		entryResult.Changes = models.GetFieldChanges(op.Previous, op.Media)
 	}
+
+	return entryResult
+}
+
+func (c *Client) applyOp(ctx context.Context, malBearerToken string, op models.SyncOp) error {
+	write := c.UpdateAnime
+
+	switch {
+	case op.Kind == models.SyncOpDelete && op.Media.Type == models.MediaTypeAnime:
+		write = c.DeleteAnime
+	case op.Kind == models.SyncOpDelete:
+		write = c.DeleteManga
+	case op.Media.Type == models.MediaTypeManga:
+		write = c.UpdateManga
+	}
+
+	return applyWrite(ctx, write, malBearerToken, op.Media)
 }
 
-// TODO: do something about repeat property
-func isMediaEqual(media1, media2 models.Media) bool {
-	idMatch := media1.ID == media2.ID
-	completeMatch := media1.Status == "completed" && media2.Status == "completed"
-	statusMatch := media1.Status == media2.Status
-	scoreMatch := media1.Score == media2.Score
-	progressMatch := media1.Progress == media2.Progress
-	lengthMismatch := media1.Length != media2.Length
+// checks whether MAL already reflects an operation
+func isOpApplied(op models.SyncOp, malData *models.SourceData) bool {
+	malMedia, ok := malData.MediaMap[op.Media.Key()]
+
+	if op.Kind == models.SyncOpDelete {
+		return !ok
+	}
+
+	return ok && hasDesiredValues(op.Media, malMedia)
+}
+
+// Time allowed for a write that was already sent when the sync is interrupted
+const writeTimeout = 30 * time.Second
+
+// writes are detached from cancellation so an interrupt never leaves a request half done,
+// the sync loop stops before the next write instead
+func applyWrite(ctx context.Context, write func(context.Context, string, models.Media) error, malBearerToken string, media models.Media) error {
+	writeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), writeTimeout)
+	defer cancel()
 
-	return idMatch &&
-		((completeMatch && scoreMatch) ||
-			(statusMatch && scoreMatch && lengthMismatch) ||
-			(progressMatch && scoreMatch && statusMatch))
+	return write(writeCtx, malBearerToken, media)
 }
@@ -0,0 +1,355 @@
+package mal
+
+import (
+	"context"
+	"errors"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// the journal and backups are kept in the configuration directory, which is found through HOME
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ani2mal-mal-test")
+	if err != nil {
+		panic(err)
+	}
+
+	os.Setenv("HOME", dir)
+	os.Setenv("APPDATA", dir)
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newTestSourceData(media ...models.Media) *models.SourceData {
+	data := models.NewSourceData()
+	for _, m := range media {
+		data.MediaMap[m.Key()] = m
+	}
+	return data
+}
+
+func testMedia(id int, status models.MediaStatus, progress, score int) models.Media {
+	return models.Media{ID: id, Title: "Title", Type: models.MediaTypeAnime, Status: status, Progress: progress, Score: score}
+}
+
+// kind and id of each op, the order of the plan matters
+func planSummary(plan []models.SyncOp) []string {
+	summary := make([]string, 0, len(plan))
+	for _, op := range plan {
+		summary = append(summary, string(op.Kind)+" "+op.Media.Key().String())
+	}
+	return summary
+}
+
+func TestPlanSync(t *testing.T) {
+	anilistData := newTestSourceData(
+		testMedia(1, models.MediaStatusCurrent, 5, 70),
+		testMedia(2, models.MediaStatusCompleted, 12, 80),
+		testMedia(3, models.MediaStatusPlanning, 0, 0),
+	)
+	malData := newTestSourceData(
+		testMedia(1, models.MediaStatusCurrent, 3, 70),
+		testMedia(2, models.MediaStatusCompleted, 12, 80),
+		testMedia(4, models.MediaStatusDropped, 2, 0),
+		testMedia(5, models.MediaStatusPaused, 1, 0),
+	)
+
+	tests := []struct {
+		name       string
+		policies   models.PolicySettings
+		exclusions map[models.MediaKey]models.Exclusion
+		ignored    []models.MediaKey
+		want       []string
+	}{
+		{
+			name: "adds updates and deletes",
+			want: []string{"add anime:3", "update anime:1", "delete anime:4", "delete anime:5"},
+		},
+		{
+			name:     "policy keeps the MAL progress",
+			policies: models.PolicySettings{Anime: models.FieldPolicies{Progress: models.PolicyPreferTarget}},
+			want:     []string{"add anime:3", "delete anime:4", "delete anime:5"},
+		},
+		{
+			name:       "excluded entries are left alone",
+			exclusions: map[models.MediaKey]models.Exclusion{{Type: models.MediaTypeAnime, ID: 1}: {Type: models.MediaTypeAnime, ID: 1}},
+			want:       []string{"add anime:3", "delete anime:4", "delete anime:5"},
+		},
+		{
+			name:    "ignored entries are not deleted",
+			ignored: []models.MediaKey{{Type: models.MediaTypeAnime, ID: 4}},
+			want:    []string{"add anime:3", "update anime:1", "delete anime:5"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anilistData.Ignored = tt.ignored
+
+			plan := PlanSync(anilistData, malData, tt.policies, tt.exclusions)
+			if got := planSummary(plan); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanSync() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanSyncUpdate(t *testing.T) {
+	anilistData := newTestSourceData(testMedia(1, models.MediaStatusCurrent, 5, 60))
+	malData := newTestSourceData(testMedia(1, models.MediaStatusCurrent, 3, 90))
+	policies := models.PolicySettings{Default: models.FieldPolicies{Score: models.PolicyMax}}
+
+	plan := PlanSync(anilistData, malData, policies, nil)
+	if len(plan) != 1 {
+		t.Fatalf("PlanSync() = %v, want one update", planSummary(plan))
+	}
+
+	op := plan[0]
+	if op.Media.Progress != 5 || op.Media.Score != 90 {
+		t.Errorf("update progress = %d, score = %d, want 5 and 90", op.Media.Progress, op.Media.Score)
+	}
+	if op.Previous == nil || *op.Previous != malData.MediaMap[op.Media.Key()] {
+		t.Errorf("update previous = %+v, want the MAL entry", op.Previous)
+	}
+}
+
+func TestPlanSyncIgnoredKeepsExclusions(t *testing.T) {
+	exclusions := map[models.MediaKey]models.Exclusion{{Type: models.MediaTypeAnime, ID: 1}: {Type: models.MediaTypeAnime, ID: 1}}
+	anilistData := newTestSourceData()
+	anilistData.Ignored = []models.MediaKey{{Type: models.MediaTypeAnime, ID: 2}}
+
+	PlanSync(anilistData, newTestSourceData(), models.PolicySettings{}, exclusions)
+	if len(exclusions) != 1 {
+		t.Errorf("PlanSync() changed the exclusions to %v", exclusions)
+	}
+}
+
+func TestPlanIncrementalSync(t *testing.T) {
+	changedData := newTestSourceData(
+		testMedia(1, models.MediaStatusCurrent, 5, 70),
+		testMedia(3, models.MediaStatusPlanning, 0, 0),
+		testMedia(6, models.MediaStatusCompleted, 24, 0),
+	)
+	malData := newTestSourceData(
+		testMedia(1, models.MediaStatusCurrent, 3, 70),
+		testMedia(4, models.MediaStatusDropped, 2, 0),
+		testMedia(6, models.MediaStatusCompleted, 24, 0),
+	)
+	exclusions := map[models.MediaKey]models.Exclusion{{Type: models.MediaTypeAnime, ID: 3}: {Type: models.MediaTypeAnime, ID: 3}}
+
+	plan := PlanIncrementalSync(changedData, malData, models.PolicySettings{}, exclusions)
+
+	// entries missing from the changed data are never deleted
+	want := []string{"update anime:1"}
+	if got := planSummary(plan); !reflect.DeepEqual(got, want) {
+		t.Errorf("PlanIncrementalSync() = %v, want %v", got, want)
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	anilistData := newTestSourceData(
+		testMedia(1, models.MediaStatusCurrent, 5, 70),
+		testMedia(2, models.MediaStatusCompleted, 12, 80),
+		testMedia(3, models.MediaStatusPlanning, 0, 0),
+		testMedia(7, models.MediaStatusPlanning, 0, 0),
+	)
+	planned := newTestSourceData(
+		testMedia(1, models.MediaStatusCurrent, 3, 70),
+		testMedia(2, models.MediaStatusCompleted, 10, 80),
+		testMedia(4, models.MediaStatusDropped, 2, 0),
+		testMedia(5, models.MediaStatusPaused, 1, 0),
+	)
+	plan := PlanSync(anilistData, planned, models.PolicySettings{}, nil)
+
+	// MAL changed after the plan was made
+	current := newTestSourceData(
+		testMedia(1, models.MediaStatusCurrent, 3, 70),
+		testMedia(2, models.MediaStatusCompleted, 11, 80),
+		testMedia(4, models.MediaStatusDropped, 2, 0),
+		testMedia(7, models.MediaStatusPlanning, 0, 0),
+	)
+
+	kept, stale := RemoveStale(plan, current)
+
+	wantKept := []string{"add anime:3", "update anime:1", "delete anime:4"}
+	if got := planSummary(kept); !reflect.DeepEqual(got, wantKept) {
+		t.Errorf("RemoveStale() kept %v, want %v", got, wantKept)
+	}
+	wantStale := []string{"add anime:7", "update anime:2", "delete anime:5"}
+	if got := planSummary(stale); !reflect.DeepEqual(got, wantStale) {
+		t.Errorf("RemoveStale() stale %v, want %v", got, wantStale)
+	}
+}
+
+// MAL server that records the writes it receives and fails the ones in failing
+func newWriteServer(t *testing.T, failing ...string) (*Client, func() []string) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := r.Method + " " + r.URL.Path
+
+		mu.Lock()
+		requests = append(requests, request)
+		mu.Unlock()
+
+		for _, f := range failing {
+			if f == request {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	getRequests := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+
+	return &Client{httpClient: server.Client(), apiUrl: server.URL}, getRequests
+}
+
+func resultOutcomes(result *models.SyncResult) []models.SyncOutcome {
+	outcomes := make([]models.SyncOutcome, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		outcomes = append(outcomes, entry.Outcome)
+	}
+	return outcomes
+}
+
+func journalStates(journal *models.SyncJournal) []models.SyncOpState {
+	states := make([]models.SyncOpState, 0, len(journal.Entries))
+	for _, entry := range journal.Entries {
+		states = append(states, entry.State)
+	}
+	return states
+}
+
+func TestApplyPlanJournal(t *testing.T) {
+	appConfig := config.GetAppConfig()
+	appConfig.RemoveSyncJournal()
+	t.Cleanup(appConfig.RemoveSyncJournal)
+
+	client, requests := newWriteServer(t, "PUT /anime/2/my_list_status")
+
+	malData := newTestSourceData(testMedia(2, models.MediaStatusCurrent, 1, 0), testMedia(3, models.MediaStatusDropped, 2, 0))
+	anilistData := newTestSourceData(testMedia(1, models.MediaStatusPlanning, 0, 0), testMedia(2, models.MediaStatusCurrent, 4, 0))
+	plan := PlanSync(anilistData, malData, models.PolicySettings{}, nil)
+
+	result, err := client.ApplyPlan(context.Background(), "token", plan, malData)
+	if err != nil {
+		t.Fatalf("ApplyPlan() error = %v", err)
+	}
+
+	wantRequests := []string{"PUT /anime/1/my_list_status", "PUT /anime/2/my_list_status", "DELETE /anime/3/my_list_status"}
+	if got := requests(); !reflect.DeepEqual(got, wantRequests) {
+		t.Errorf("requests = %v, want %v", got, wantRequests)
+	}
+
+	wantOutcomes := []models.SyncOutcome{models.SyncOutcomeApplied, models.SyncOutcomeFailed, models.SyncOutcomeApplied}
+	if got := resultOutcomes(result); !reflect.DeepEqual(got, wantOutcomes) {
+		t.Errorf("outcomes = %v, want %v", got, wantOutcomes)
+	}
+
+	// failed entries are left for the next sync, the journal itself is finished
+	journal := appConfig.GetSyncJournal()
+	if journal == nil || !journal.IsComplete() {
+		t.Fatalf("journal = %+v, want a finished journal", journal)
+	}
+	wantStates := []models.SyncOpState{models.SyncOpDone, models.SyncOpFailed, models.SyncOpDone}
+	if got := journalStates(journal); !reflect.DeepEqual(got, wantStates) {
+		t.Errorf("journal states = %v, want %v", got, wantStates)
+	}
+}
+
+func TestApplyPlanInterrupted(t *testing.T) {
+	appConfig := config.GetAppConfig()
+	appConfig.RemoveSyncJournal()
+	t.Cleanup(appConfig.RemoveSyncJournal)
+
+	client, requests := newWriteServer(t)
+
+	malData := newTestSourceData()
+	plan := PlanSync(newTestSourceData(testMedia(1, models.MediaStatusPlanning, 0, 0)), malData, models.PolicySettings{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := client.ApplyPlan(ctx, "token", plan, malData)
+	if err != nil {
+		t.Fatalf("ApplyPlan() error = %v", err)
+	}
+	if !result.Interrupted || len(requests()) != 0 {
+		t.Errorf("result = %+v with %d requests, want an interrupted sync without requests", result, len(requests()))
+	}
+
+	journal := appConfig.GetSyncJournal()
+	if journal == nil || journal.IsComplete() {
+		t.Fatalf("journal = %+v, want an unfinished journal", journal)
+	}
+
+	// the pending entries would be lost if a new plan replaced the journal
+	_, err = client.ApplyPlan(context.Background(), "token", plan, malData)
+	if !errors.Is(err, ErrUnfinishedSync) {
+		t.Errorf("ApplyPlan() over an unfinished journal error = %v, want ErrUnfinishedSync", err)
+	}
+}
+
+func TestResumeSync(t *testing.T) {
+	appConfig := config.GetAppConfig()
+	t.Cleanup(appConfig.RemoveSyncJournal)
+
+	client, requests := newWriteServer(t)
+
+	previous := testMedia(2, models.MediaStatusCurrent, 1, 0)
+	journal := &models.SyncJournal{
+		StartedAt: 1,
+		Entries: []models.SyncJournalEntry{
+			{Op: models.SyncOp{Kind: models.SyncOpAdd, Media: testMedia(1, models.MediaStatusPlanning, 0, 0)}, State: models.SyncOpDone},
+			{Op: models.SyncOp{Kind: models.SyncOpUpdate, Media: testMedia(2, models.MediaStatusCurrent, 4, 0), Previous: &previous}, State: models.SyncOpPending},
+			{Op: models.SyncOp{Kind: models.SyncOpAdd, Media: testMedia(3, models.MediaStatusPlanning, 0, 0)}, State: models.SyncOpPending},
+			{Op: models.SyncOp{Kind: models.SyncOpDelete, Media: testMedia(4, models.MediaStatusDropped, 2, 0)}, State: models.SyncOpFailed, Error: "status code: 500"},
+		},
+	}
+	appConfig.SaveSyncJournal(journal)
+
+	// the update reached MAL before the sync stopped
+	malData := newTestSourceData(
+		testMedia(1, models.MediaStatusPlanning, 0, 0),
+		testMedia(2, models.MediaStatusCurrent, 4, 0),
+		testMedia(4, models.MediaStatusDropped, 2, 0),
+	)
+
+	result := client.ResumeSync(context.Background(), "token", appConfig.GetSyncJournal(), malData)
+
+	wantRequests := []string{"PUT /anime/3/my_list_status", "DELETE /anime/4/my_list_status"}
+	if got := requests(); !reflect.DeepEqual(got, wantRequests) {
+		t.Errorf("requests = %v, want %v", got, wantRequests)
+	}
+
+	wantOutcomes := []models.SyncOutcome{models.SyncOutcomeAlreadyApplied, models.SyncOutcomeApplied, models.SyncOutcomeApplied}
+	if got := resultOutcomes(result); !reflect.DeepEqual(got, wantOutcomes) {
+		t.Errorf("outcomes = %v, want %v", got, wantOutcomes)
+	}
+
+	saved := appConfig.GetSyncJournal()
+	if saved == nil || !saved.IsComplete() {
+		t.Fatalf("journal = %+v, want a finished journal", saved)
+	}
+	wantStates := []models.SyncOpState{models.SyncOpDone, models.SyncOpDone, models.SyncOpDone, models.SyncOpDone}
+	if got := journalStates(saved); !reflect.DeepEqual(got, wantStates) {
+		t.Errorf("journal states = %v, want %v", got, wantStates)
+	}
+}
@@ -0,0 +1,31 @@
+package metrics
+
+// Latency buckets in seconds for API requests
+var requestBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Duration buckets in seconds for whole syncs
+var syncBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+var (
+	SyncRuns = NewCounter("ani2mal_sync_runs_total",
+		"Syncs run by the daemon by result (success, partial, failed or skipped).", "result")
+	SyncDuration = NewHistogram("ani2mal_sync_duration_seconds",
+		"Time taken by each sync, including fetching both libraries.", syncBuckets)
+	LastSuccessfulSync = NewGauge("ani2mal_last_successful_sync_timestamp_seconds",
+		"Unix time the last sync without failures finished.")
+	SyncEntries = NewCounter("ani2mal_sync_entries_total",
+		"Planned MAL changes by operation, media type and outcome.", "op", "type", "outcome")
+
+	APIRequests = NewCounter("ani2mal_api_requests_total",
+		"HTTP requests sent to each service by status code, code is \"error\" when no response was received.", "service", "code")
+	APIRequestDuration = NewHistogram("ani2mal_api_request_duration_seconds",
+		"Latency of HTTP requests sent to each service by status code, code is \"error\" when no response was received.", requestBuckets, "service", "code")
+
+	RateLimitWaits = NewCounter("ani2mal_rate_limit_waits_total",
+		"Times a request waited because the service reported a rate limit.", "service")
+	RateLimitWaitSeconds = NewCounter("ani2mal_rate_limit_wait_seconds_total",
+		"Time spent waiting for rate limits to reset.", "service")
+
+	TokenRefreshes = NewCounter("ani2mal_token_refreshes_total",
+		"Access token refreshes by result (success or failure).", "service", "result")
+)
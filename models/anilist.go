@@ -1,24 +1,67 @@
 package models
 
-// Configuration file format
+// Configuration file format.
+// Username is what was entered at login, ViewerName is the owner of the token and may differ
 type AnilistConfig struct {
-	Username     string   `json:"username"`
-	ClientId     string   `json:"client_id"`
-	ClientSecret string   `json:"client_secret"`
-	TokenRes     TokenRes `json:"token_res"`
+	Username     string                  `json:"username"`
+	ViewerName   string                  `json:"viewer_name,omitempty"`
+	UserId       int                     `json:"user_id,omitempty"`
+	ListOptions  AnilistMediaListOptions `json:"list_options"`
+	ClientId     string                  `json:"client_id"`
+	ClientSecret string                  `json:"client_secret"`
+	TokenRes     TokenRes                `json:"token_res"`
 }
 
-// Response from Anilist Lists (Anime, Manga)
-type AnilistRes struct {
-	Data AnilistResData `json:"data"`
+// Response data from the Viewer query
+type AnilistViewerData struct {
+	Viewer *AnilistViewer `json:"Viewer"`
 }
 
+// User that owns the access token
+type AnilistViewer struct {
+	ID               int                     `json:"id"`
+	Name             string                  `json:"name"`
+	MediaListOptions AnilistMediaListOptions `json:"mediaListOptions"`
+}
+
+type AnilistMediaListOptions struct {
+	ScoreFormat string                 `json:"scoreFormat"`
+	RowOrder    string                 `json:"rowOrder"`
+	AnimeList   AnilistListTypeOptions `json:"animeList"`
+	MangaList   AnilistListTypeOptions `json:"mangaList"`
+}
+
+type AnilistListTypeOptions struct {
+	SplitCompletedSectionByFormat bool     `json:"splitCompletedSectionByFormat"`
+	CustomLists                   []string `json:"customLists"`
+}
+
+// Response data from Anilist Lists (Anime, Manga)
 type AnilistResData struct {
-	MediaListCollection AnilistMediaListCollection `json:"MediaListCollection"`
+	MediaListCollection *AnilistMediaListCollection `json:"MediaListCollection"`
 }
 
 type AnilistMediaListCollection struct {
-	Lists []AnilistList `json:"lists"`
+	HasNextChunk bool          `json:"hasNextChunk"`
+	Lists        []AnilistList `json:"lists"`
+}
+
+type AnilistPageData struct {
+	Page *AnilistPage `json:"Page"`
+}
+
+type AnilistPage struct {
+	PageInfo  AnilistPageInfo `json:"pageInfo"`
+	MediaList []AnilistEntry  `json:"mediaList"`
+}
+
+type AnilistPageInfo struct {
+	HasNextPage bool `json:"hasNextPage"`
+}
+
+type AnilistError struct {
+	Message string `json:"message"`
+	Status  int    `json:"status"`
 }
 
 type AnilistList struct {
@@ -30,23 +73,37 @@ type AnilistList struct {
 }
 
 type AnilistEntry struct {
-	ID       int          `json:"id"`
-	Status   string       `json:"status"`
-	Score    float64      `json:"score"`
-	Progress int          `json:"progress"`
-	Notes    *string      `json:"notes"`
-	Repeat   int          `json:"repeat"`
-	Media    AnilistMedia `json:"media"`
+	ID          int              `json:"id"`
+	Status      string           `json:"status"`
+	Score       float64          `json:"score"`
+	Progress    int              `json:"progress"`
+	Notes       *string          `json:"notes"`
+	Repeat      int              `json:"repeat"`
+	UpdatedAt   int64            `json:"updatedAt"`
+	StartedAt   AnilistFuzzyDate `json:"startedAt"`
+	CompletedAt AnilistFuzzyDate `json:"completedAt"`
+	Media       AnilistMedia     `json:"media"`
 }
 
 type AnilistMedia struct {
-	Chapters *int         `json:"chapters"`
-	Volumes  *int         `json:"volumes"`
-	IDMal    *int         `json:"idMal"`
-	Episodes *int         `json:"episodes"`
-	Title    AnilistTitle `json:"title"`
+	ID        int              `json:"id"`
+	Chapters  *int             `json:"chapters"`
+	Volumes   *int             `json:"volumes"`
+	IDMal     *int             `json:"idMal"`
+	Episodes  *int             `json:"episodes"`
+	Duration  *int             `json:"duration"`
+	Format    string           `json:"format"`
+	StartDate AnilistFuzzyDate `json:"startDate"`
+	Title     AnilistTitle     `json:"title"`
+}
+
+type AnilistFuzzyDate struct {
+	Year  *int `json:"year"`
+	Month *int `json:"month"`
+	Day   *int `json:"day"`
 }
 
 type AnilistTitle struct {
-	Romaji string `json:"romaji"`
+	Romaji  string  `json:"romaji"`
+	English *string `json:"english"`
 }
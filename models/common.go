@@ -1,20 +1,51 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type AppError struct {
 	Message string
 	Err     error
 }
 
 func (e *AppError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
 	return e.Message
 }
 
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
 // Response after exchanging auth code
 type TokenRes struct {
 	TokenType    string `json:"token_type"`
 	ExpiresIn    int    `json:"expires_in"`
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	// Unix time the access token expires, not part of the response
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// records when the token expires, ExpiresIn counts from roughly when the token was requested
+func (t *TokenRes) SetExpiry(requestedAt time.Time) {
+	t.ExpiresAt = requestedAt.Add(time.Duration(t.ExpiresIn) * time.Second).Unix()
+}
+
+// tokens saved before the expiry time was recorded are treated as expiring
+func (t *TokenRes) ExpiresWithin(d time.Duration) bool {
+	return t.ExpiresAt == 0 || time.Until(time.Unix(t.ExpiresAt, 0)) < d
+}
+
+func (t *TokenRes) IsExpired() bool {
+	return t.ExpiresAt != 0 && time.Now().Unix() >= t.ExpiresAt
 }
 
 // general format to store anime / manga
@@ -36,6 +67,36 @@ const (
 	MediaStatusCompleted MediaStatus = "completed"
 )
 
+// Identifies an entry across both media types, MAL anime and manga IDs overlap
+type MediaKey struct {
+	Type MediaType
+	ID   int
+}
+
+func (k MediaKey) String() string {
+	return fmt.Sprintf("%s:%d", k.Type, k.ID)
+}
+
+func (k MediaKey) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *MediaKey) UnmarshalText(text []byte) error {
+	mediaType, id, ok := strings.Cut(string(text), ":")
+	if !ok {
+		return fmt.Errorf("invalid media key %q, expected <type>:<id>", text)
+	}
+
+	parsedId, err := strconv.Atoi(id)
+	if err != nil {
+		return fmt.Errorf("invalid media key %q: %w", text, err)
+	}
+
+	k.Type = MediaType(mediaType)
+	k.ID = parsedId
+	return nil
+}
+
 type Media struct {
 	ID       int         `json:"id"`
 	Title    string      `json:"title"`
@@ -45,19 +106,100 @@ type Media struct {
 	Repeat   bool        `json:"repeat,omitempty"`
 	Type     MediaType   `json:"type"`
 	Status   MediaStatus `json:"status"`
+	// Unix time the entry was last changed on the service it was fetched from
+	UpdatedAt int64 `json:"updated_at,omitempty"`
+	// Minutes per episode, only known for Anilist anime
+	Duration int `json:"duration,omitempty"`
+	// YYYY-MM-DD, or shorter when only the year or month is known
+	StartDate  string `json:"start_date,omitempty"`
+	FinishDate string `json:"finish_date,omitempty"`
+	Notes      string `json:"notes,omitempty"`
 }
 
-type SourceStats struct {
-	Planning  int `json:"planning"`
-	Paused    int `json:"paused"`
-	Current   int `json:"current"`
-	Dropped   int `json:"dropped"`
-	Completed int `json:"completed"`
+func (m Media) Key() MediaKey {
+	return MediaKey{Type: m.Type, ID: m.ID}
+}
+
+// Anilist entry that has no MAL ID and therefore can't be synced
+type UnmappedMedia struct {
+	AnilistID int      `json:"anilist_id"`
+	Format    string   `json:"format,omitempty"`
+	Year      int      `json:"year,omitempty"`
+	AltTitles []string `json:"alt_titles,omitempty"`
+	Media     Media    `json:"media"`
+}
+
+// User defined override for the MAL ID of an Anilist entry
+type IdMapping struct {
+	AnilistID int  `json:"anilist_id"`
+	MalID     int  `json:"mal_id,omitempty"`
+	Ignore    bool `json:"ignore,omitempty"`
 }
 
 type SourceData struct {
-	Stats    SourceStats   `json:"stats"`
-	MediaMap map[int]Media `json:"media_map"`
-	Anime    []Media       `json:"anime"`
-	Manga    []Media       `json:"manga"`
+	MediaMap map[MediaKey]Media `json:"media_map"`
+	Anime    []Media            `json:"anime"`
+	Manga    []Media            `json:"manga"`
+	Unmapped []UnmappedMedia    `json:"unmapped,omitempty"`
+	// MAL entries of Anilist entries with an ignore mapping, sync leaves them alone
+	Ignored []MediaKey `json:"ignored,omitempty"`
+}
+
+func NewSourceData() *SourceData {
+	return &SourceData{
+		MediaMap: make(map[MediaKey]Media),
+		Anime:    make([]Media, 0),
+		Manga:    make([]Media, 0),
+		Unmapped: make([]UnmappedMedia, 0),
+	}
+}
+
+// adds the entries and stats of another source into this one
+func (d *SourceData) Merge(other *SourceData) {
+	for key, media := range other.MediaMap {
+		d.MediaMap[key] = media
+	}
+
+	d.Anime = append(d.Anime, other.Anime...)
+	d.Manga = append(d.Manga, other.Manga...)
+	d.Unmapped = append(d.Unmapped, other.Unmapped...)
+	d.Ignored = append(d.Ignored, other.Ignored...)
+}
+
+// The media map is always rebuilt from the anime and manga lists. Snapshots stored
+// before media keys had a type used bare MAL IDs as keys and can't be read as is
+func (d *SourceData) UnmarshalJSON(data []byte) error {
+	type sourceData SourceData
+	var raw struct {
+		sourceData
+		MediaMap json.RawMessage `json:"media_map"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*d = SourceData(raw.sourceData)
+	d.RebuildMediaMap()
+
+	return nil
+}
+
+func (d *SourceData) RebuildMediaMap() {
+	d.MediaMap = make(map[MediaKey]Media, len(d.Anime)+len(d.Manga))
+
+	for _, media := range d.Anime {
+		d.MediaMap[media.Key()] = media
+	}
+	for _, media := range d.Manga {
+		d.MediaMap[media.Key()] = media
+	}
+}
+
+// Snapshot of a list taken before it was changed
+type Backup struct {
+	Name      string     `json:"name"`
+	Service   string     `json:"service"`
+	CreatedAt int64      `json:"created_at"`
+	Data      SourceData `json:"data"`
 }
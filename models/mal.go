@@ -33,6 +33,9 @@ type MalListStatus struct {
 	IsRewatching       bool   `json:"is_rewatching"`
 	UpdatedAt          string `json:"updated_at"`
 	IsRereading        bool   `json:"is_rereading"`
+	StartDate          string `json:"start_date"`
+	FinishDate         string `json:"finish_date"`
+	Comments           string `json:"comments"`
 }
 
 type MalNode struct {
@@ -51,3 +54,38 @@ type MalNodeMainPicture struct {
 type MalListPaging struct {
 	Next string `json:"next"`
 }
+
+// Response from MAL search endpoints (/anime, /manga)
+type MalSearchRes struct {
+	Data   []MalSearchDatum `json:"data"`
+	Paging MalListPaging    `json:"paging,omitempty"`
+}
+
+type MalSearchDatum struct {
+	Node MalSearchNode `json:"node"`
+}
+
+type MalSearchNode struct {
+	ID                int                  `json:"id"`
+	Title             string               `json:"title"`
+	AlternativeTitles MalAlternativeTitles `json:"alternative_titles"`
+	MediaType         string               `json:"media_type"`
+	StartDate         string               `json:"start_date"`
+	NumEpisodes       int                  `json:"num_episodes"`
+	NumChapters       int                  `json:"num_chapters"`
+}
+
+type MalAlternativeTitles struct {
+	Synonyms []string `json:"synonyms"`
+	En       string   `json:"en"`
+	Ja       string   `json:"ja"`
+}
+
+// Possible MAL match for an unmapped Anilist entry
+type MalCandidate struct {
+	ID         int     `json:"id"`
+	Title      string  `json:"title"`
+	MediaType  string  `json:"media_type"`
+	Year       int     `json:"year,omitempty"`
+	Confidence float64 `json:"confidence"`
+}
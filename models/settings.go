@@ -0,0 +1,170 @@
+package models
+
+import "fmt"
+
+// Optional user settings, every field falls back to a default when left empty
+type Settings struct {
+	HTTP    HTTPSettings    `json:"http"`
+	Anilist AnilistSettings `json:"anilist"`
+	Mal     MalSettings     `json:"mal"`
+	Backup  BackupSettings  `json:"backup"`
+	Daemon  DaemonSettings  `json:"daemon"`
+	Sync    SyncSettings    `json:"sync"`
+	Cache   CacheSettings   `json:"cache"`
+	// Webhooks called after every sync
+	Notify []NotifySettings `json:"notify,omitempty"`
+}
+
+type HTTPSettings struct {
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+	UserAgent      string `json:"user_agent,omitempty"`
+	// Proxy for all requests, the HTTP_PROXY/HTTPS_PROXY environment variables are used when empty
+	ProxyURL string `json:"proxy_url,omitempty"`
+}
+
+type AnilistSettings struct {
+	GraphQLURL string `json:"graphql_url,omitempty"`
+	OAuthURL   string `json:"oauth_url,omitempty"`
+}
+
+type MalSettings struct {
+	APIURL   string `json:"api_url,omitempty"`
+	OAuthURL string `json:"oauth_url,omitempty"`
+}
+
+type BackupSettings struct {
+	// Number of backups to keep, older ones are deleted after each new backup
+	Retention int `json:"retention,omitempty"`
+}
+
+type DaemonSettings struct {
+	IntervalMinutes int `json:"interval_minutes,omitempty"`
+	// Random delay added to each interval so runs don't line up with other scheduled jobs
+	JitterMinutes int `json:"jitter_minutes,omitempty"`
+	// Upper bound for the delay between retries after failed syncs
+	MaxBackoffMinutes int `json:"max_backoff_minutes,omitempty"`
+}
+
+type SyncSettings struct {
+	// Hours between full syncs, the runs in between only fetch Anilist entries changed since the last sync.
+	// Full syncs are needed to notice entries removed from Anilist
+	FullSyncHours int `json:"full_sync_hours,omitempty"`
+	// How each field is resolved when an entry exists on both sides
+	Policies PolicySettings `json:"policies"`
+}
+
+// Default applies to both media types, Anime and Manga override it field by field
+type PolicySettings struct {
+	Default FieldPolicies `json:"default"`
+	Anime   FieldPolicies `json:"anime"`
+	Manga   FieldPolicies `json:"manga"`
+}
+
+type FieldPolicies struct {
+	Status   ConflictPolicy `json:"status,omitempty"`
+	Score    ConflictPolicy `json:"score,omitempty"`
+	Progress ConflictPolicy `json:"progress,omitempty"`
+	// Start and finish dates
+	Dates ConflictPolicy `json:"dates,omitempty"`
+	Notes ConflictPolicy `json:"notes,omitempty"`
+}
+
+type CacheSettings struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// How long list and search responses are reused before they are fetched again
+	TTLMinutes int `json:"ttl_minutes,omitempty"`
+}
+
+type NotifySettings struct {
+	// Identifies the webhook in logs
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+	// Extra request headers, for example an Authorization header
+	Headers map[string]string `json:"headers,omitempty"`
+	// text/template for the request body. The sync result is sent as JSON when empty
+	Template    string `json:"template,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// Only notify when some changes failed or the sync itself failed
+	OnlyOnFailure bool `json:"only_on_failure,omitempty"`
+	// Only notify when entries were deleted from MAL. When both filters are set either one is enough
+	OnlyOnDeletes bool `json:"only_on_deletes,omitempty"`
+}
+
+type ConflictPolicy string
+
+const (
+	PolicyPreferSource ConflictPolicy = "prefer_source"
+	PolicyPreferTarget ConflictPolicy = "prefer_target"
+	// Larger number or later date
+	PolicyMax ConflictPolicy = "max"
+	// Value of the side whose entry was updated last, the source wins ties
+	PolicyLatestUpdated ConflictPolicy = "latest_updated"
+	// Source value unless the target already has a value
+	PolicyNeverOverwriteNonzero ConflictPolicy = "never_overwrite_nonzero"
+)
+
+// Used for fields no policy is set for. Dates and notes were not synced before
+// policies existed, so by default they are only written to new entries
+var defaultFieldPolicies = FieldPolicies{
+	Status:   PolicyPreferSource,
+	Score:    PolicyPreferSource,
+	Progress: PolicyPreferSource,
+	Dates:    PolicyPreferTarget,
+	Notes:    PolicyPreferTarget,
+}
+
+// returns the policies for a media type with every field set
+func (p PolicySettings) For(mediaType MediaType) FieldPolicies {
+	override := p.Anime
+	if mediaType == MediaTypeManga {
+		override = p.Manga
+	}
+
+	return FieldPolicies{
+		Status:   firstPolicy(override.Status, p.Default.Status, defaultFieldPolicies.Status),
+		Score:    firstPolicy(override.Score, p.Default.Score, defaultFieldPolicies.Score),
+		Progress: firstPolicy(override.Progress, p.Default.Progress, defaultFieldPolicies.Progress),
+		Dates:    firstPolicy(override.Dates, p.Default.Dates, defaultFieldPolicies.Dates),
+		Notes:    firstPolicy(override.Notes, p.Default.Notes, defaultFieldPolicies.Notes),
+	}
+}
+
+// max has no meaning for statuses and notes
+func (p PolicySettings) Validate() error {
+	for name, policies := range map[string]FieldPolicies{"default": p.Default, "anime": p.Anime, "manga": p.Manga} {
+		fields := []struct {
+			name     string
+			policy   ConflictPolicy
+			allowMax bool
+		}{
+			{"status", policies.Status, false},
+			{"score", policies.Score, true},
+			{"progress", policies.Progress, true},
+			{"dates", policies.Dates, true},
+			{"notes", policies.Notes, false},
+		}
+
+		for _, field := range fields {
+			switch field.policy {
+			case "", PolicyPreferSource, PolicyPreferTarget, PolicyLatestUpdated, PolicyNeverOverwriteNonzero:
+			case PolicyMax:
+				if !field.allowMax {
+					return fmt.Errorf("Policy %q can't be used for %s.%s", field.policy, name, field.name)
+				}
+			default:
+				return fmt.Errorf("Unknown policy %q for %s.%s", field.policy, name, field.name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func firstPolicy(policies ...ConflictPolicy) ConflictPolicy {
+	for _, policy := range policies {
+		if policy != "" {
+			return policy
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicySettingsFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		settings  PolicySettings
+		mediaType MediaType
+		want      FieldPolicies
+	}{
+		{
+			name:      "built in defaults",
+			settings:  PolicySettings{},
+			mediaType: MediaTypeAnime,
+			want:      defaultFieldPolicies,
+		},
+		{
+			name:      "default section fills unset fields",
+			settings:  PolicySettings{Default: FieldPolicies{Score: PolicyMax, Notes: PolicyPreferSource}},
+			mediaType: MediaTypeManga,
+			want:      FieldPolicies{Status: PolicyPreferSource, Score: PolicyMax, Progress: PolicyPreferSource, Dates: PolicyPreferTarget, Notes: PolicyPreferSource},
+		},
+		{
+			name:      "media type section wins over default",
+			settings:  PolicySettings{Default: FieldPolicies{Progress: PolicyMax}, Manga: FieldPolicies{Progress: PolicyLatestUpdated}},
+			mediaType: MediaTypeManga,
+			want:      FieldPolicies{Status: PolicyPreferSource, Score: PolicyPreferSource, Progress: PolicyLatestUpdated, Dates: PolicyPreferTarget, Notes: PolicyPreferTarget},
+		},
+		{
+			name:      "other media type section is ignored",
+			settings:  PolicySettings{Default: FieldPolicies{Progress: PolicyMax}, Manga: FieldPolicies{Progress: PolicyLatestUpdated}},
+			mediaType: MediaTypeAnime,
+			want:      FieldPolicies{Status: PolicyPreferSource, Score: PolicyPreferSource, Progress: PolicyMax, Dates: PolicyPreferTarget, Notes: PolicyPreferTarget},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.settings.For(tt.mediaType); got != tt.want {
+				t.Errorf("For() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicySettingsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings PolicySettings
+		wantErr  string
+	}{
+		{"empty", PolicySettings{}, ""},
+		{"every valid policy", PolicySettings{Default: FieldPolicies{Status: PolicyLatestUpdated, Score: PolicyMax, Progress: PolicyNeverOverwriteNonzero, Dates: PolicyMax, Notes: PolicyPreferSource}}, ""},
+		{"max for status", PolicySettings{Anime: FieldPolicies{Status: PolicyMax}}, "anime.status"},
+		{"max for notes", PolicySettings{Manga: FieldPolicies{Notes: PolicyMax}}, "manga.notes"},
+		{"unknown policy", PolicySettings{Default: FieldPolicies{Score: "newest"}}, "Unknown policy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+type SyncOpKind string
+
+const (
+	SyncOpAdd    SyncOpKind = "add"
+	SyncOpUpdate SyncOpKind = "update"
+	SyncOpDelete SyncOpKind = "delete"
+)
+
+// Single change to apply to the target list.
+// Media is the desired entry for adds and updates and the entry to remove for deletes,
+// Previous is the target entry before the change
+type SyncOp struct {
+	Kind     SyncOpKind `json:"kind"`
+	Media    Media      `json:"media"`
+	Previous *Media     `json:"previous,omitempty"`
+}
+
+type SyncOpState string
+
+const (
+	SyncOpPending SyncOpState = "pending"
+	SyncOpDone    SyncOpState = "done"
+	SyncOpFailed  SyncOpState = "failed"
+)
+
+type SyncJournalEntry struct {
+	Op    SyncOp      `json:"op"`
+	State SyncOpState `json:"state"`
+	Error string      `json:"error,omitempty"`
+}
+
+// Write-ahead record of a sync, saved before any change is made and after each one
+type SyncJournal struct {
+	StartedAt  int64              `json:"started_at"`
+	FinishedAt int64              `json:"finished_at,omitempty"`
+	Entries    []SyncJournalEntry `json:"entries"`
+}
+
+func (j *SyncJournal) IsComplete() bool {
+	return j.FinishedAt != 0
+}
+
+type SyncOutcome string
+
+const (
+	SyncOutcomeApplied        SyncOutcome = "applied"
+	SyncOutcomeFailed         SyncOutcome = "failed"
+	SyncOutcomeNotApplied     SyncOutcome = "not_applied"
+	SyncOutcomeAlreadyApplied SyncOutcome = "already_applied"
+)
+
+// Field that differs between the target entry and the desired entry
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+type SyncEntryResult struct {
+	Op         SyncOpKind    `json:"op"`
+	Media      Media         `json:"media"`
+	Changes    []FieldChange `json:"changes,omitempty"`
+	Outcome    SyncOutcome   `json:"outcome"`
+	Error      string        `json:"error,omitempty"`
+	DurationMs int64         `json:"duration_ms"`
+}
+
+// Outcome of every operation in a sync
+type SyncResult struct {
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  time.Time         `json:"finished_at"`
+	Interrupted bool              `json:"interrupted"`
+	Entries     []SyncEntryResult `json:"entries"`
+}
+
+func (r *SyncResult) Count(op SyncOpKind, outcome SyncOutcome) int {
+	count := 0
+	for _, entry := range r.Entries {
+		if (op == "" || entry.Op == op) && entry.Outcome == outcome {
+			count++
+		}
+	}
+	return count
+}
+
+// true when some operations were not applied, either because they failed or the sync was interrupted
+func (r *SyncResult) IsPartial() bool {
+	return r.Count("", SyncOutcomeFailed) > 0 || r.Count("", SyncOutcomeNotApplied) > 0
+}
+
+// lists the synced fields that differ between from and to, from is nil for new entries
+func GetFieldChanges(from *Media, to Media) []FieldChange {
+	changes := make([]FieldChange, 0)
+
+	var previous Media
+	if from != nil {
+		previous = *from
+	}
+
+	if previous.Status != to.Status {
+		changes = append(changes, FieldChange{Field: "status", From: string(previous.Status), To: string(to.Status)})
+	}
+	if previous.Score != to.Score || from == nil {
+		changes = append(changes, FieldChange{Field: "score", From: strconv.Itoa(previous.Score), To: strconv.Itoa(to.Score)})
+	}
+	if previous.Progress != to.Progress || from == nil {
+		changes = append(changes, FieldChange{Field: "progress", From: strconv.Itoa(previous.Progress), To: strconv.Itoa(to.Progress)})
+	}
+	if previous.StartDate != to.StartDate {
+		changes = append(changes, FieldChange{Field: "start_date", From: previous.StartDate, To: to.StartDate})
+	}
+	if previous.FinishDate != to.FinishDate {
+		changes = append(changes, FieldChange{Field: "finish_date", From: previous.FinishDate, To: to.FinishDate})
+	}
+	if previous.Notes != to.Notes {
+		changes = append(changes, FieldChange{Field: "notes", From: previous.Notes, To: to.Notes})
+	}
+
+	return changes
+}
+
+// Progress of incremental syncs, kept per profile
+type SyncState struct {
+	// Newest Anilist updatedAt seen by a sync that finished without failures
+	AnilistUpdatedAt int64 `json:"anilist_updated_at"`
+	LastFullSyncAt   int64 `json:"last_full_sync_at"`
+}
+
+// Entry that syncs never add, update or delete on MAL
+type Exclusion struct {
+	Type  MediaType `json:"type"`
+	ID    int       `json:"id"`
+	Title string    `json:"title,omitempty"`
+}
+
+func (e Exclusion) Key() MediaKey {
+	return MediaKey{Type: e.Type, ID: e.ID}
+}
@@ -0,0 +1,45 @@
+package models
+
+// Providers that can appear in the cross-reference database
+const (
+	XrefProviderAnilist   = "anilist"
+	XrefProviderMal       = "mal"
+	XrefProviderKitsu     = "kitsu"
+	XrefProviderAnidb     = "anidb"
+	XrefProviderAnisearch = "anisearch"
+	XrefProviderLivechart = "livechart"
+	XrefProviderSimkl     = "simkl"
+)
+
+// On disk index of an imported cross-reference dataset
+type XrefIndex struct {
+	Source     string      `json:"source"`
+	LastUpdate string      `json:"last_update,omitempty"`
+	ImportedAt int64       `json:"imported_at"`
+	Entries    []XrefEntry `json:"entries"`
+}
+
+// IDs of a single title on each provider
+type XrefEntry struct {
+	Title string         `json:"title"`
+	Type  MediaType      `json:"type"`
+	Year  int            `json:"year,omitempty"`
+	IDs   map[string]int `json:"ids"`
+}
+
+// Format of the anime-offline-database JSON file
+type OfflineDatabase struct {
+	LastUpdate string                 `json:"lastUpdate"`
+	Data       []OfflineDatabaseEntry `json:"data"`
+}
+
+type OfflineDatabaseEntry struct {
+	Sources     []string              `json:"sources"`
+	Title       string                `json:"title"`
+	AnimeSeason OfflineDatabaseSeason `json:"animeSeason"`
+}
+
+type OfflineDatabaseSeason struct {
+	Season string `json:"season"`
+	Year   int    `json:"year"`
+}
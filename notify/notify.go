@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"ipmanlk/ani2mal/models"
+	"log/slog"
+	"net/http"
+	"text/template"
+)
+
+// Outcome of a sync sent to the webhooks. Result is nil when the sync failed before any change was applied
+type Event struct {
+	Profile string             `json:"profile,omitempty"`
+	Error   string             `json:"error,omitempty"`
+	Result  *models.SyncResult `json:"result,omitempty"`
+}
+
+func NewEvent(profile string, result *models.SyncResult, err error) Event {
+	event := Event{Profile: profile, Result: result}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return event
+}
+
+func (e Event) count(op models.SyncOpKind, outcome models.SyncOutcome) int {
+	if e.Result == nil {
+		return 0
+	}
+	return e.Result.Count(op, outcome)
+}
+
+func (e Event) Added() int   { return e.count(models.SyncOpAdd, models.SyncOutcomeApplied) }
+func (e Event) Updated() int { return e.count(models.SyncOpUpdate, models.SyncOutcomeApplied) }
+func (e Event) Deleted() int { return e.count(models.SyncOpDelete, models.SyncOutcomeApplied) }
+func (e Event) Failed() int  { return e.count("", models.SyncOutcomeFailed) }
+
+// an interrupted sync leaves changes unapplied without anything having failed
+func (e Event) IsFailure() bool {
+	if e.Error != "" || e.Failed() > 0 {
+		return true
+	}
+	return e.Result != nil && !e.Result.Interrupted && e.count("", models.SyncOutcomeNotApplied) > 0
+}
+
+// one line description for chat messages
+func (e Event) Summary() string {
+	if e.Result == nil {
+		return "Sync failed: " + e.Error
+	}
+
+	summary := fmt.Sprintf("Added %d, updated %d, deleted %d, failed %d", e.Added(), e.Updated(), e.Deleted(), e.Failed())
+	if e.Result.Interrupted {
+		summary += " (interrupted)"
+	}
+	if e.Error != "" {
+		summary += ": " + e.Error
+	}
+	return summary
+}
+
+// calls every webhook whose filters match. Failures are logged rather than returned
+// so a broken webhook never fails the sync
+func Send(ctx context.Context, client *http.Client, sinks []models.NotifySettings, event Event) {
+	for i, sink := range sinks {
+		name := sink.Name
+		if name == "" {
+			name = fmt.Sprintf("notify[%d]", i)
+		}
+
+		if !shouldNotify(sink, event) {
+			slog.Debug("Skipped notification, filters did not match", "webhook", name)
+			continue
+		}
+
+		if err := send(ctx, client, sink, event); err != nil {
+			slog.Warn("Failed to send notification", "webhook", name, "error", err)
+			continue
+		}
+
+		slog.Debug("Sent notification", "webhook", name)
+	}
+}
+
+func shouldNotify(sink models.NotifySettings, event Event) bool {
+	if !sink.OnlyOnFailure && !sink.OnlyOnDeletes {
+		return true
+	}
+	return (sink.OnlyOnFailure && event.IsFailure()) || (sink.OnlyOnDeletes && event.Deleted() > 0)
+}
+
+func send(ctx context.Context, client *http.Client, sink models.NotifySettings, event Event) error {
+	body, contentType, err := renderBody(sink, event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.URL, bytes.NewReader(body))
+	if err != nil {
+		return &models.AppError{
+			Message: "Invalid webhook URL",
+			Err:     err,
+		}
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range sink.Headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return &models.AppError{
+			Message: fmt.Sprintf("Webhook returned %s", res.Status),
+		}
+	}
+
+	return nil
+}
+
+// the template gets the event, json marshals a value for templates that build JSON bodies
+func renderBody(sink models.NotifySettings, event Event) ([]byte, string, error) {
+	if sink.Template == "" {
+		body, err := json.Marshal(event)
+		return body, "application/json", err
+	}
+
+	tmpl, err := template.New("notify").Funcs(template.FuncMap{
+		"json": func(value any) (string, error) {
+			encoded, err := json.Marshal(value)
+			return string(encoded), err
+		},
+	}).Parse(sink.Template)
+	if err != nil {
+		return nil, "", &models.AppError{
+			Message: "Invalid notification template",
+			Err:     err,
+		}
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, event); err != nil {
+		return nil, "", &models.AppError{
+			Message: "Failed to render the notification template",
+			Err:     err,
+		}
+	}
+
+	contentType := sink.ContentType
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+
+	return body.Bytes(), contentType, nil
+}
@@ -0,0 +1,109 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"ipmanlk/ani2mal/models"
+	"strings"
+)
+
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+func IsValidFormat(format string) bool {
+	return format == FormatText || format == FormatJSON || format == FormatMarkdown
+}
+
+// writes a sync result in the given format
+func Write(w io.Writer, format string, result *models.SyncResult) error {
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, result)
+	case FormatMarkdown:
+		return writeMarkdown(w, result)
+	case FormatText:
+		return writeText(w, result)
+	default:
+		return fmt.Errorf("Unknown report format %q, expected %s, %s or %s", format, FormatText, FormatJSON, FormatMarkdown)
+	}
+}
+
+func writeJSON(w io.Writer, result *models.SyncResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", " ")
+	return encoder.Encode(result)
+}
+
+func writeText(w io.Writer, result *models.SyncResult) error {
+	var b strings.Builder
+
+	if result.Interrupted {
+		b.WriteString("\nSync interrupted. Run `ani2mal sync --resume` to apply the remaining changes.\n")
+	}
+
+	fmt.Fprintf(&b, "Applied: %d, Failed: %d, Not applied: %d\n",
+		result.Count("", models.SyncOutcomeApplied), result.Count("", models.SyncOutcomeFailed), result.Count("", models.SyncOutcomeNotApplied))
+
+	for _, entry := range result.Entries {
+		switch entry.Outcome {
+		case models.SyncOutcomeFailed:
+			fmt.Fprintf(&b, "  Failed: %s [%s] %s (MAL ID: %d): %s\n", entry.Op, entry.Media.Type, entry.Media.Title, entry.Media.ID, entry.Error)
+		case models.SyncOutcomeNotApplied:
+			fmt.Fprintf(&b, "  Not applied: %s [%s] %s (MAL ID: %d)\n", entry.Op, entry.Media.Type, entry.Media.Title, entry.Media.ID)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeMarkdown(w io.Writer, result *models.SyncResult) error {
+	var b strings.Builder
+
+	b.WriteString("# Sync report\n\n")
+	fmt.Fprintf(&b, "- Started: %s\n", result.StartedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, "- Finished: %s\n", result.FinishedAt.Format("2006-01-02 15:04:05"))
+	if result.Interrupted {
+		b.WriteString("- **Interrupted**, run `ani2mal sync --resume` to apply the remaining changes\n")
+	}
+
+	b.WriteString("\n| Operation | Applied | Failed | Not applied |\n|---|---|---|---|\n")
+	for _, op := range []models.SyncOpKind{models.SyncOpAdd, models.SyncOpUpdate, models.SyncOpDelete} {
+		fmt.Fprintf(&b, "| %s | %d | %d | %d |\n", op,
+			result.Count(op, models.SyncOutcomeApplied), result.Count(op, models.SyncOutcomeFailed), result.Count(op, models.SyncOutcomeNotApplied))
+	}
+
+	if len(result.Entries) > 0 {
+		b.WriteString("\n| Op | Type | Title | MAL ID | Changes | Outcome | Duration |\n|---|---|---|---|---|---|---|\n")
+	}
+
+	for _, entry := range result.Entries {
+		outcome := string(entry.Outcome)
+		if entry.Error != "" {
+			outcome += ": " + entry.Error
+		}
+
+		fmt.Fprintf(&b, "| %s | %s | %s | %d | %s | %s | %dms |\n",
+			entry.Op, entry.Media.Type, escapeMarkdown(entry.Media.Title), entry.Media.ID,
+			escapeMarkdown(formatChanges(entry.Changes)), escapeMarkdown(outcome), entry.DurationMs)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatChanges(changes []models.FieldChange) string {
+	formatted := make([]string, len(changes))
+	for i, change := range changes {
+		formatted[i] = fmt.Sprintf("%s: %s → %s", change.Field, change.From, change.To)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func escapeMarkdown(value string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(value)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/mal"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Number of candidates offered to the user for each unmapped entry
+const maxCandidates = 5
+
+func printUnmappedReport(unmapped []models.UnmappedMedia) {
+	fmt.Printf("%d Anilist entries have no MAL ID and are not synced:\n", len(unmapped))
+
+	for _, entry := range unmapped {
+		fmt.Printf("  [%s] %s (Anilist ID: %d, %s)\n", entry.Media.Type, entry.Media.Title, entry.AnilistID, describeUnmapped(entry))
+	}
+}
+
+func printUnmappedNotice(data *models.SourceData) {
+	if len(data.Unmapped) == 0 {
+		return
+	}
+
+	slog.Warn("Anilist entries without a MAL ID will be skipped, run `ani2mal unmapped` to list them", "count", len(data.Unmapped))
+}
+
+// searches MAL for each unmapped entry and moves matched ones into the media map,
+// matches are saved as ID mappings so they are only resolved once
+func resolveUnmapped(ctx context.Context, s *session, data *models.SourceData, interactive, autoMatch bool) {
+	remaining := make([]models.UnmappedMedia, 0)
+	appConfig := config.GetAppConfig()
+	mappings := appConfig.GetIdMappings()
+	defer appConfig.SaveIdMappings(mappings)
+
+	for _, entry := range data.Unmapped {
+		candidates, err := s.mal.FindCandidates(ctx, s.malCode, entry)
+		if err != nil {
+			slog.Warn("Failed to search MAL", "title", entry.Media.Title, "error", err)
+			remaining = append(remaining, entry)
+			continue
+		}
+
+		candidate, ok := models.MalCandidate{}, false
+		if autoMatch {
+			candidate, ok = mal.GetAutoMatch(candidates)
+		}
+
+		if ok {
+			fmt.Printf("Matched %s -> %s (MAL ID: %d, %.0f%%)\n", entry.Media.Title, candidate.Title, candidate.ID, candidate.Confidence*100)
+		} else if interactive {
+			candidate, ok = pickCandidate(entry, candidates)
+		}
+
+		if !ok {
+			remaining = append(remaining, entry)
+			continue
+		}
+
+		mappings[entry.AnilistID] = models.IdMapping{AnilistID: entry.AnilistID, MalID: candidate.ID}
+
+		media := entry.Media
+		media.ID = candidate.ID
+		addResolvedMedia(data, media)
+	}
+
+	data.Unmapped = remaining
+}
+
+func pickCandidate(entry models.UnmappedMedia, candidates []models.MalCandidate) (models.MalCandidate, bool) {
+	fmt.Printf("\n%s (%s, %s)\n", entry.Media.Title, entry.Media.Type, describeUnmapped(entry))
+
+	if len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+
+	for i, candidate := range candidates {
+		fmt.Printf("  %d) %s (MAL ID: %d, %s, %d) %.0f%%\n", i+1, candidate.Title, candidate.ID, candidate.MediaType, candidate.Year, candidate.Confidence*100)
+	}
+
+	for {
+		fmt.Print("Pick a number, enter a MAL ID as #<id>, or leave empty to skip: ")
+		input := strings.TrimSpace(utils.GetStrInput())
+
+		if input == "" {
+			return models.MalCandidate{}, false
+		}
+
+		if strings.HasPrefix(input, "#") {
+			malId, err := strconv.Atoi(input[1:])
+			if err == nil && malId > 0 {
+				return models.MalCandidate{ID: malId, Title: entry.Media.Title}, true
+			}
+		}
+
+		choice, err := strconv.Atoi(input)
+		if err == nil && choice >= 1 && choice <= len(candidates) {
+			return candidates[choice-1], true
+		}
+
+		fmt.Println("Invalid choice.")
+	}
+}
+
+func addResolvedMedia(data *models.SourceData, media models.Media) {
+	if media.Type == models.MediaTypeAnime {
+		data.Anime = append(data.Anime, media)
+	} else {
+		data.Manga = append(data.Manga, media)
+	}
+
+	data.MediaMap[media.Key()] = media
+}
+
+func describeUnmapped(entry models.UnmappedMedia) string {
+	details := make([]string, 0, 2)
+
+	if entry.Format != "" {
+		details = append(details, entry.Format)
+	}
+	if entry.Year != 0 {
+		details = append(details, strconv.Itoa(entry.Year))
+	}
+	if len(details) == 0 {
+		return "unknown format"
+	}
+
+	return strings.Join(details, ", ")
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/models"
+	"ipmanlk/ani2mal/utils"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const reviewHelp = `  a  apply this change
+  s  skip it for this sync
+  x  skip it and exclude the entry from future syncs
+  e  edit the values before applying
+  A  apply this and every remaining change of the same kind
+  q  skip every remaining change
+`
+
+var knownStatuses = []models.MediaStatus{
+	models.MediaStatusPlanning,
+	models.MediaStatusCurrent,
+	models.MediaStatusCompleted,
+	models.MediaStatusPaused,
+	models.MediaStatusDropped,
+}
+
+// walks through the plan one change at a time and returns the approved changes, with any edits.
+// Entries skipped forever are saved as exclusions
+func reviewPlan(plan []models.SyncOp) []models.SyncOp {
+	approved := make([]models.SyncOp, 0, len(plan))
+	if len(plan) == 0 {
+		return approved
+	}
+
+	appConfig := config.GetAppConfig()
+	applyAll := make(map[models.SyncOpKind]bool)
+
+	fmt.Fprintf(os.Stderr, "Reviewing %d changes. Enter ? for help.\n", len(plan))
+
+	for i, op := range plan {
+		if applyAll[op.Kind] {
+			approved = append(approved, op)
+			continue
+		}
+
+		printReviewOp(i, len(plan), op)
+
+	prompt:
+		for {
+			fmt.Fprint(os.Stderr, "[a]pply, [s]kip, e[x]clude, [e]dit, [A]pply all remaining "+string(op.Kind)+"s, [q]uit: ")
+			input, err := utils.ReadInput()
+			if err != nil {
+				fmt.Fprintln(os.Stderr)
+				fmt.Fprintln(os.Stderr, "No more input, skipping the remaining changes.")
+				return approved
+			}
+
+			switch strings.TrimSpace(input) {
+			case "a":
+				approved = append(approved, op)
+				break prompt
+			case "s":
+				break prompt
+			case "x":
+				exclusions := appConfig.GetExclusions()
+				exclusions[op.Media.Key()] = models.Exclusion{Type: op.Media.Type, ID: op.Media.ID, Title: op.Media.Title}
+				appConfig.SaveExclusions(exclusions)
+				fmt.Fprintf(os.Stderr, "Excluded %s from future syncs, run `ani2mal exclude remove %s` to undo\n", op.Media.Title, op.Media.Key())
+				break prompt
+			case "e":
+				if op.Kind == models.SyncOpDelete {
+					fmt.Fprintln(os.Stderr, "Deletes can't be edited.")
+					continue
+				}
+				op.Media = editMedia(op.Media)
+				printReviewOp(i, len(plan), op)
+			case "A":
+				applyAll[op.Kind] = true
+				approved = append(approved, op)
+				break prompt
+			case "q":
+				return approved
+			default:
+				fmt.Fprint(os.Stderr, reviewHelp)
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Approved %d of %d changes.\n", len(approved), len(plan))
+
+	return approved
+}
+
+// shows the MAL and Anilist values side by side, changed fields are marked with *
+func printReviewOp(index, total int, op models.SyncOp) {
+	fmt.Fprintf(os.Stderr, "\n(%d/%d) %s [%s] %s (MAL ID: %d)\n", index+1, total, op.Kind, op.Media.Type, op.Media.Title, op.Media.ID)
+
+	var malMedia, anilistMedia *models.Media
+	switch op.Kind {
+	case models.SyncOpAdd:
+		anilistMedia = &op.Media
+	case models.SyncOpUpdate:
+		malMedia, anilistMedia = op.Previous, &op.Media
+	case models.SyncOpDelete:
+		malMedia = &op.Media
+	}
+
+	fields := []struct {
+		name  string
+		value func(models.Media) string
+	}{
+		{"status", func(m models.Media) string { return string(m.Status) }},
+		{"score", func(m models.Media) string { return strconv.Itoa(m.Score) }},
+		{"progress", func(m models.Media) string { return strconv.Itoa(m.Progress) }},
+		{"start date", func(m models.Media) string { return m.StartDate }},
+		{"finish date", func(m models.Media) string { return m.FinishDate }},
+		{"notes", func(m models.Media) string { return m.Notes }},
+	}
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "\tMAL\tAnilist\t")
+
+	for _, field := range fields {
+		malValue, anilistValue := "-", "-"
+		if malMedia != nil {
+			malValue = field.value(*malMedia)
+		}
+		if anilistMedia != nil {
+			anilistValue = field.value(*anilistMedia)
+		}
+
+		marker := ""
+		if malValue != anilistValue {
+			marker = "*"
+		}
+
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", field.name, shortenNotes(malValue), shortenNotes(anilistValue), marker)
+	}
+
+	w.Flush()
+}
+
+// prompts for each synced field, an empty answer keeps the current value
+func editMedia(media models.Media) models.Media {
+	statuses := make([]string, len(knownStatuses))
+	for i, status := range knownStatuses {
+		statuses[i] = string(status)
+	}
+
+	media.Status = models.MediaStatus(promptField(
+		fmt.Sprintf("  status (%s) [%s]: ", strings.Join(statuses, ", "), media.Status),
+		string(media.Status),
+		func(input string) bool {
+			for _, status := range knownStatuses {
+				if input == string(status) {
+					return true
+				}
+			}
+			return false
+		},
+	))
+
+	score := promptField(fmt.Sprintf("  score (0-10) [%d]: ", media.Score), strconv.Itoa(media.Score), func(input string) bool {
+		value, err := strconv.Atoi(input)
+		return err == nil && value >= 0 && value <= 10
+	})
+	media.Score, _ = strconv.Atoi(score)
+
+	progressHint := ""
+	if media.Length > 0 {
+		progressHint = fmt.Sprintf(" (0-%d)", media.Length)
+	}
+	progress := promptField(fmt.Sprintf("  progress%s [%d]: ", progressHint, media.Progress), strconv.Itoa(media.Progress), func(input string) bool {
+		value, err := strconv.Atoi(input)
+		return err == nil && value >= 0 && (media.Length == 0 || value <= media.Length)
+	})
+	media.Progress, _ = strconv.Atoi(progress)
+
+	// MAL can't clear a date or notes, so there is no way to ask for an empty value
+	media.StartDate = promptField(fmt.Sprintf("  start date (YYYY-MM-DD) [%s]: ", media.StartDate), media.StartDate, isValidDate)
+	media.FinishDate = promptField(fmt.Sprintf("  finish date (YYYY-MM-DD) [%s]: ", media.FinishDate), media.FinishDate, isValidDate)
+	media.Notes = promptField(fmt.Sprintf("  notes [%s]: ", shortenNotes(media.Notes)), media.Notes, func(string) bool { return true })
+
+	return media
+}
+
+// accepts the full and partial dates Anilist and MAL use
+func isValidDate(input string) bool {
+	for _, layout := range []string{time.DateOnly, "2006-01", "2006"} {
+		if _, err := time.Parse(layout, input); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// keeps long or multi-line values such as notes on one short line
+func shortenNotes(notes string) string {
+	notes = strings.Join(strings.Fields(notes), " ")
+	if runes := []rune(notes); len(runes) > 40 {
+		return string(runes[:37]) + "..."
+	}
+	return notes
+}
+
+func promptField(prompt, current string, isValid func(string) bool) string {
+	for {
+		fmt.Fprint(os.Stderr, prompt)
+		input, err := utils.ReadInput()
+		input = strings.TrimSpace(input)
+
+		if err != nil || input == "" {
+			return current
+		}
+		if isValid(input) {
+			return input
+		}
+
+		fmt.Fprintln(os.Stderr, "Invalid value.")
+	}
+}
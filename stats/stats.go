@@ -0,0 +1,118 @@
+package stats
+
+import (
+	"ipmanlk/ani2mal/models"
+	"sort"
+)
+
+// Statuses in the order they are shown
+var Statuses = []models.MediaStatus{
+	models.MediaStatusCurrent,
+	models.MediaStatusCompleted,
+	models.MediaStatusPaused,
+	models.MediaStatusDropped,
+	models.MediaStatusPlanning,
+}
+
+// Summary of the entries of one media type
+type TypeStats struct {
+	Type   models.MediaType           `json:"type"`
+	Total  int                        `json:"total"`
+	Status map[models.MediaStatus]int `json:"status"`
+	// Entries with a score, unscored entries are left out of the mean and median
+	Scored      int     `json:"scored"`
+	MeanScore   float64 `json:"mean_score"`
+	MedianScore float64 `json:"median_score"`
+	// Number of entries per score, index 0 counts the unscored entries
+	ScoreHistogram [11]int `json:"score_histogram"`
+	// Episodes watched or chapters read
+	Progress int `json:"progress"`
+	// Anime only, episodes of entries with an unknown duration are not counted
+	WatchMinutes int `json:"watch_minutes,omitempty"`
+	// Anime entries with progress but no known episode duration
+	UnknownDuration int `json:"unknown_duration,omitempty"`
+}
+
+type Stats struct {
+	Anime TypeStats `json:"anime"`
+	Manga TypeStats `json:"manga"`
+}
+
+// summarises a library. Durations holds the minutes per episode by media key,
+// MAL doesn't return them so both libraries are measured with the Anilist durations
+func Compute(data *models.SourceData, durations map[models.MediaKey]int) *Stats {
+	return &Stats{
+		Anime: computeType(models.MediaTypeAnime, data.Anime, durations),
+		Manga: computeType(models.MediaTypeManga, data.Manga, durations),
+	}
+}
+
+// collects the known episode durations of a library
+func Durations(data *models.SourceData) map[models.MediaKey]int {
+	durations := make(map[models.MediaKey]int)
+	for key, media := range data.MediaMap {
+		if media.Duration > 0 {
+			durations[key] = media.Duration
+		}
+	}
+	return durations
+}
+
+func computeType(mediaType models.MediaType, entries []models.Media, durations map[models.MediaKey]int) TypeStats {
+	stats := TypeStats{Type: mediaType, Total: len(entries), Status: make(map[models.MediaStatus]int)}
+	scores := make([]int, 0, len(entries))
+
+	for _, media := range entries {
+		stats.Status[media.Status]++
+		stats.Progress += media.Progress
+
+		if media.Score >= 0 && media.Score <= 10 {
+			stats.ScoreHistogram[media.Score]++
+		}
+		if media.Score > 0 {
+			scores = append(scores, media.Score)
+		}
+
+		if mediaType != models.MediaTypeAnime || media.Progress == 0 {
+			continue
+		}
+		if duration, ok := durations[media.Key()]; ok {
+			stats.WatchMinutes += duration * media.Progress
+		} else {
+			stats.UnknownDuration++
+		}
+	}
+
+	stats.Scored = len(scores)
+	stats.MeanScore = mean(scores)
+	stats.MedianScore = median(scores)
+
+	return stats
+}
+
+func mean(values []int) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sum := 0
+	for _, value := range values {
+		sum += value
+	}
+	return float64(sum) / float64(len(values))
+}
+
+func median(values []int) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return float64(sorted[middle-1]+sorted[middle]) / 2
+	}
+	return float64(sorted[middle])
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"fmt"
+	"ipmanlk/ani2mal/cache"
+	"ipmanlk/ani2mal/metrics"
+	"ipmanlk/ani2mal/models"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultTimeout   = 15 * time.Second
+	defaultUserAgent = "ani2mal"
+)
+
+// builds the HTTP client a service client reuses for all of its requests,
+// service names the API in request logs and cache entries. A nil store turns caching off
+func NewHTTPClient(service string, settings models.HTTPSettings, store *cache.Store) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if settings.ProxyURL != "" {
+		proxyURL, err := url.Parse(settings.ProxyURL)
+		if err != nil {
+			return nil, &models.AppError{
+				Message: fmt.Sprintf("Invalid proxy URL %q", settings.ProxyURL),
+				Err:     err,
+			}
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	timeout := defaultTimeout
+	if settings.TimeoutSeconds > 0 {
+		timeout = time.Duration(settings.TimeoutSeconds) * time.Second
+	}
+
+	userAgent := defaultUserAgent
+	if settings.UserAgent != "" {
+		userAgent = settings.UserAgent
+	}
+
+	var roundTripper http.RoundTripper = &metricsTransport{
+		service: service,
+		next: &loggingTransport{
+			service: service,
+			next: &userAgentTransport{
+				userAgent: userAgent,
+				next:      transport,
+			},
+		},
+	}
+
+	// the cache sits outside the metrics and logging so only requests that reach the network are counted
+	if store != nil {
+		roundTripper = store.Transport(service, roundTripper)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: roundTripper,
+	}, nil
+}
+
+// sets the User-Agent header on every request
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
+// counts requests and their latency by service and status code
+type metricsTransport struct {
+	service string
+	next    http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	startedAt := time.Now()
+	res, err := t.next.RoundTrip(req)
+	duration := time.Since(startedAt)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+	metrics.APIRequests.Inc(t.service, code)
+	metrics.APIRequestDuration.Observe(duration.Seconds(), t.service, code)
+
+	return res, err
+}
+
+// logs every request at debug level. Headers and bodies are never logged
+// since they carry tokens and client secrets
+type loggingTransport struct {
+	service string
+	next    http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return t.next.RoundTrip(req)
+	}
+
+	startedAt := time.Now()
+	res, err := t.next.RoundTrip(req)
+	duration := time.Since(startedAt)
+
+	attrs := []any{
+		"service", t.service,
+		"method", req.Method,
+		"url", RedactURL(req.URL),
+		"duration_ms", duration.Milliseconds(),
+	}
+
+	if err != nil {
+		slog.DebugContext(ctx, "HTTP request failed", append(attrs, "error", err)...)
+		return res, err
+	}
+
+	slog.DebugContext(ctx, "HTTP request", append(attrs, "status", res.StatusCode)...)
+	return res, nil
+}
+
+// query parameters that can carry credentials
+var sensitiveParams = []string{"access_token", "refresh_token", "code", "code_verifier", "code_challenge", "client_secret", "token"}
+
+// returns the URL with credentials in the user info and query replaced
+func RedactURL(u *url.URL) string {
+	redacted := *u
+
+	if redacted.User != nil {
+		redacted.User = url.User("[redacted]")
+	}
+
+	if redacted.RawQuery != "" {
+		query := redacted.Query()
+		for _, param := range sensitiveParams {
+			if query.Has(param) {
+				query.Set(param, "[redacted]")
+			}
+		}
+		redacted.RawQuery = query.Encode()
+	}
+
+	return redacted.String()
+}
+
+// returns value unless it is empty
+func OrDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// configures the default logger, logs always go to stderr so they never mix with command output
+func SetupLogger(w io.Writer, level slog.Level, format string) error {
+	options := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case LogFormatText:
+		slog.SetDefault(slog.New(slog.NewTextHandler(w, options)))
+	case LogFormatJSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, options)))
+	default:
+		return fmt.Errorf("Unknown log format %q, expected %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+
+	return nil
+}
+
+// logs an error and exits
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -2,21 +2,25 @@ package utils
 
 import (
 	"bufio"
+	"io"
 	"os"
 )
 
-func GetStrInput() string {
-	// 	reader := bufio.NewReader(os.Stdin)
-	// 	input, err := reader.ReadString('\n')
-
-	// 	if err != nil {
-	// 		log.Fatal(err)
-	// }
-
-	// 	return strings.TrimSpace(input)
+// shared so lines buffered by one read are not lost to the next, which matters when input is piped
+var stdin = bufio.NewScanner(os.Stdin)
 
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Scan()
-	input := scanner.Text()
+func GetStrInput() string {
+	input, _ := ReadInput()
 	return input
 }
+
+// reads a line from stdin, io.EOF is returned once the input is closed
+func ReadInput() (string, error) {
+	if !stdin.Scan() {
+		if err := stdin.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return stdin.Text(), nil
+}
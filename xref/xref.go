@@ -0,0 +1,169 @@
+package xref
+
+import (
+	"encoding/json"
+	"ipmanlk/ani2mal/config"
+	"ipmanlk/ani2mal/models"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Provider for each host that appears in the dataset source URLs
+var providerHosts = map[string]string{
+	"anilist.co":      models.XrefProviderAnilist,
+	"myanimelist.net": models.XrefProviderMal,
+	"kitsu.app":       models.XrefProviderKitsu,
+	"kitsu.io":        models.XrefProviderKitsu,
+	"anidb.net":       models.XrefProviderAnidb,
+	"anisearch.com":   models.XrefProviderAnisearch,
+	"livechart.me":    models.XrefProviderLivechart,
+	"simkl.com":       models.XrefProviderSimkl,
+}
+
+type indexKey struct {
+	provider  string
+	mediaType models.MediaType
+	id        int
+}
+
+// Cross-reference database that translates IDs between providers
+type Database struct {
+	index   map[indexKey]int
+	entries []models.XrefEntry
+}
+
+// loads the imported database from the config directory, returns nil when nothing has been imported
+func Load() *Database {
+	index := config.GetAppConfig().GetXrefIndex()
+	if index == nil {
+		return nil
+	}
+
+	return newDatabase(index)
+}
+
+// parses an anime-offline-database JSON file and saves it as the cross-reference index
+func Import(path string) (*models.XrefIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to open cross-reference dataset",
+			Err:     err,
+		}
+	}
+	defer file.Close()
+
+	var dataset models.OfflineDatabase
+	err = json.NewDecoder(file).Decode(&dataset)
+	if err != nil {
+		return nil, &models.AppError{
+			Message: "Failed to parse cross-reference dataset",
+			Err:     err,
+		}
+	}
+
+	index := &models.XrefIndex{
+		Source:     filepath.Base(path),
+		LastUpdate: dataset.LastUpdate,
+		ImportedAt: time.Now().Unix(),
+		Entries:    make([]models.XrefEntry, 0, len(dataset.Data)),
+	}
+
+	for _, item := range dataset.Data {
+		entry := models.XrefEntry{
+			Title: item.Title,
+			Year:  item.AnimeSeason.Year,
+			IDs:   make(map[string]int),
+		}
+
+		for _, source := range item.Sources {
+			provider, mediaType, id, ok := parseSourceURL(source)
+			if !ok {
+				continue
+			}
+			entry.Type = mediaType
+			entry.IDs[provider] = id
+		}
+
+		// a single provider can't be translated to anything
+		if len(entry.IDs) < 2 {
+			continue
+		}
+
+		index.Entries = append(index.Entries, entry)
+	}
+
+	config.GetAppConfig().SaveXrefIndex(index)
+
+	return index, nil
+}
+
+// translates an ID from one provider to another
+func (db *Database) Translate(mediaType models.MediaType, from string, id int, to string) (int, bool) {
+	entry, ok := db.Lookup(mediaType, from, id)
+	if !ok {
+		return 0, false
+	}
+
+	translatedId, ok := entry.IDs[to]
+	return translatedId, ok
+}
+
+// finds the entry for an ID on the given provider
+func (db *Database) Lookup(mediaType models.MediaType, provider string, id int) (models.XrefEntry, bool) {
+	i, ok := db.index[indexKey{provider: provider, mediaType: mediaType, id: id}]
+	if !ok {
+		return models.XrefEntry{}, false
+	}
+
+	return db.entries[i], true
+}
+
+func newDatabase(index *models.XrefIndex) *Database {
+	db := &Database{
+		index:   make(map[indexKey]int),
+		entries: index.Entries,
+	}
+
+	for i, entry := range index.Entries {
+		for provider, id := range entry.IDs {
+			db.index[indexKey{provider: provider, mediaType: entry.Type, id: id}] = i
+		}
+	}
+
+	return db
+}
+
+// extracts the provider, media type and ID from URLs like https://anilist.co/anime/1
+func parseSourceURL(source string) (string, models.MediaType, int, bool) {
+	sourceURL, err := url.Parse(source)
+	if err != nil {
+		return "", "", 0, false
+	}
+
+	provider, ok := providerHosts[strings.TrimPrefix(sourceURL.Hostname(), "www.")]
+	if !ok {
+		return "", "", 0, false
+	}
+
+	segments := strings.Split(strings.Trim(sourceURL.Path, "/"), "/")
+	if len(segments) < 2 {
+		return "", "", 0, false
+	}
+
+	mediaType := models.MediaType(segments[len(segments)-2])
+	if mediaType != models.MediaTypeAnime && mediaType != models.MediaTypeManga {
+		return "", "", 0, false
+	}
+
+	id, err := strconv.Atoi(segments[len(segments)-1])
+	if err != nil {
+		return "", "", 0, false
+	}
+
+	return provider, mediaType, id, true
+}